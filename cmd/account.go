@@ -0,0 +1,269 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"rmatic-relay/pkg/utils"
+	"strings"
+	"text/tabwriter"
+
+	gethKeystore "github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+	"github.com/stafiprotocol/chainbridge/utils/keystore"
+)
+
+var (
+	flagPrivateKeyFile   = "private_key_file"
+	flagGethKeystore     = "geth_keystore"
+	flagGethPasswordFile = "geth_password_file"
+	flagNewPasswordFile  = "new_password_file"
+)
+
+func accountCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "account",
+		Short: "Manage the keys of the keystore",
+	}
+	cmd.AddCommand(
+		accountListCmd(),
+		accountImportCmd(),
+		accountChangePasswordCmd(),
+	)
+	return cmd
+}
+
+func accountListCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Args:  cobra.ExactArgs(0),
+		Short: "List the accounts of the keystore",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keystorePath, err := cmd.Flags().GetString(flagKeystorePath)
+			if err != nil {
+				return err
+			}
+			entries, err := os.ReadDir(keystorePath)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "ADDRESS\tTYPE\tFILE\n")
+			for _, entry := range entries {
+				if entry.IsDir() || filepath.Ext(entry.Name()) != ".key" {
+					continue
+				}
+				file := filepath.Join(keystorePath, entry.Name())
+				bts, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				var ks keystore.EncryptedKeystore
+				if err := json.Unmarshal(bts, &ks); err != nil {
+					fmt.Fprintf(w, "%s\t%s\t%s\n", strings.TrimSuffix(entry.Name(), ".key"), "invalid", file)
+					continue
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\n", ks.Address, ks.Type, file)
+			}
+			return w.Flush()
+		},
+	}
+	cmd.Flags().String(flagKeystorePath, defaultKeystorePath, "Keystore file path")
+	return cmd
+}
+
+func accountImportCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "import",
+		Args:  cobra.ExactArgs(0),
+		Short: "Import a private key from a file or stdin, or a geth keystore json",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keystorePath, err := cmd.Flags().GetString(flagKeystorePath)
+			if err != nil {
+				return err
+			}
+			privateKeyFile, err := cmd.Flags().GetString(flagPrivateKeyFile)
+			if err != nil {
+				return err
+			}
+			gethKeystoreFile, err := cmd.Flags().GetString(flagGethKeystore)
+			if err != nil {
+				return err
+			}
+			if (len(privateKeyFile) == 0) == (len(gethKeystoreFile) == 0) {
+				return fmt.Errorf("exactly one of --%s and --%s is required", flagPrivateKeyFile, flagGethKeystore)
+			}
+			newPasswordFile, err := cmd.Flags().GetString(flagNewPasswordFile)
+			if err != nil {
+				return err
+			}
+			// the password prompt reads stdin too, which the key already consumed
+			if privateKeyFile == "-" && len(newPasswordFile) == 0 {
+				return fmt.Errorf("--%s is required when the key is read from stdin", flagNewPasswordFile)
+			}
+
+			var kp *secp256k1.Keypair
+			if len(privateKeyFile) != 0 {
+				kp, err = readPrivateKey(privateKeyFile)
+			} else {
+				kp, err = readGethKeystore(cmd, gethKeystoreFile)
+			}
+			if err != nil {
+				return err
+			}
+
+			password, err := readNewPassword(cmd, "password for key:")
+			if err != nil {
+				return err
+			}
+			fp, err := writeKeyFile(keystorePath, kp, password)
+			if err != nil {
+				return err
+			}
+			logrus.WithFields(logrus.Fields{
+				"address": kp.Address(),
+				"file":    fp,
+			}).Info("key imported")
+			return nil
+		},
+	}
+	cmd.Flags().String(flagKeystorePath, defaultKeystorePath, "Keystore file path")
+	cmd.Flags().String(flagPrivateKeyFile, "", "File holding the hex private key, - reads it from stdin")
+	cmd.Flags().String(flagGethKeystore, "", "Geth keystore json file to import")
+	cmd.Flags().String(flagGethPasswordFile, "", "File holding the password of --geth_keystore, prompted when empty")
+	cmd.Flags().String(flagNewPasswordFile, "", "File holding the password of the imported key, prompted when empty")
+	return cmd
+}
+
+func accountChangePasswordCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "change-password",
+		Args:  cobra.ExactArgs(0),
+		Short: "Re-encrypt a key of the keystore with a new password",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keystorePath, err := cmd.Flags().GetString(flagKeystorePath)
+			if err != nil {
+				return err
+			}
+			account, err := cmd.Flags().GetString(flagAccount)
+			if err != nil {
+				return err
+			}
+			if !common.IsHexAddress(account) {
+				return fmt.Errorf("account %q is not a hex address", account)
+			}
+			kp, err := loadKeypair(cmd, account, keystorePath)
+			if err != nil {
+				return err
+			}
+			password, err := readNewPassword(cmd, "new password for key:")
+			if err != nil {
+				return err
+			}
+
+			// write aside and rename, the old key file stays intact on failure
+			fp := filepath.Join(keystorePath, account+".key")
+			tmp := fp + ".tmp"
+			// a tmp file left by an interrupted run would fail the O_EXCL open
+			if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			file, err := os.OpenFile(tmp, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			err = keystore.EncryptAndWriteToFile(file, kp, password)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(tmp)
+				return fmt.Errorf("could not write key to file: %s", err)
+			}
+			if err := os.Rename(tmp, fp); err != nil {
+				os.Remove(tmp)
+				return err
+			}
+			logrus.WithFields(logrus.Fields{
+				"address": kp.Address(),
+				"file":    fp,
+			}).Info("key password changed")
+			return nil
+		},
+	}
+	cmd.Flags().String(flagKeystorePath, defaultKeystorePath, "Keystore file path")
+	cmd.Flags().String(flagAccount, "", "Account hex address")
+	cmd.Flags().String(flagNewPasswordFile, "", "File holding the new password, prompted when empty")
+	addPasswordFlags(cmd)
+	return cmd
+}
+
+// readPrivateKey reads a hex private key from file, or from stdin for -.
+func readPrivateKey(file string) (*secp256k1.Keypair, error) {
+	var bts []byte
+	var err error
+	if file == "-" {
+		bts, err = io.ReadAll(os.Stdin)
+	} else {
+		bts, err = utils.ReadSecretFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	kp, err := secp256k1.NewKeypairFromString(strings.TrimPrefix(strings.TrimSpace(string(bts)), "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate secp256k1 keypair from given string: %s", err)
+	}
+	return kp, nil
+}
+
+// readGethKeystore decrypts a geth keystore json with the password of
+// --geth_password_file, or a prompted one.
+func readGethKeystore(cmd *cobra.Command, file string) (*secp256k1.Keypair, error) {
+	keyJson, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	passwordFile, err := cmd.Flags().GetString(flagGethPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	var password []byte
+	if len(passwordFile) != 0 {
+		password, err = (&utils.PasswordSource{File: passwordFile, Fd: -1}).Read()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		password = keystore.GetPassword(fmt.Sprintf("Enter password for geth keystore %s:", file))
+	}
+	key, err := gethKeystore.DecryptKey(keyJson, string(password))
+	if err != nil {
+		return nil, fmt.Errorf("decrypt geth keystore %s failed: %w", file, err)
+	}
+	return secp256k1.NewKeypair(*key.PrivateKey), nil
+}
+
+// readNewPassword reads the password from --new_password_file, or prompts for
+// it twice.
+func readNewPassword(cmd *cobra.Command, prompt string) ([]byte, error) {
+	file, err := cmd.Flags().GetString(flagNewPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(file) != 0 {
+		return (&utils.PasswordSource{File: file, Fd: -1}).Read()
+	}
+	password := keystore.GetPassword(prompt)
+	if !bytes.Equal(password, keystore.GetPassword("repeat the password:")) {
+		return nil, fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
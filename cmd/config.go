@@ -0,0 +1,168 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"rmatic-relay/pkg/config"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagConfig = "config"
+	flagDryRun = "dry-run"
+
+	defaultConfig = ""
+)
+
+func configCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Config utilities",
+	}
+	cmd.AddCommand(configCheckCmd())
+	return cmd
+}
+
+func configCheckCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "check",
+		Args:  cobra.ExactArgs(0),
+		Short: "Validate the config used by run, start and sync-rate",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+			fmt.Println("config ok")
+			return nil
+		},
+	}
+	addRunFlags(cmd)
+	return cmd
+}
+
+// configFlags maps config keys to the flags that can override them.
+var configFlags = map[string]string{
+	"Tasks":                         flagTasks,
+	"EthRpcEndpoint":                flagEthEndpoint,
+	"PolygonRpcEndpoint":            flagPolygonEndpoint,
+	"Account":                       flagAccount,
+	"SignerEndpoint":                flagSignerEndpoint,
+	"GasLimit":                      flagGasLimit,
+	"MaxGasPrice":                   flagMaxGasPrice,
+	"EthGasPriceMode":               flagEthGasPriceMode,
+	"PolygonGasPriceMode":           flagPolygonGasPriceMode,
+	"ReplaceTxBlocks":               flagReplaceTxBlocks,
+	"EthConfirmBlocks":              flagEthConfirmBlocks,
+	"PolygonConfirmBlocks":          flagPolygonConfirmBlocks,
+	"StakeMangerAddress":            flagStakeManager,
+	"PolygonStakePortalRateAddress": flagStakePortalRate,
+	"LogLevel":                      flagLogLevel,
+	"MetricsListenAddr":             flagMetricsAddr,
+	"EthMinBalance":                 flagEthMinBalance,
+	"PolygonMinBalance":             flagPolygonMinBalance,
+	"ReadyTickIntervals":            flagReadyTickIntervals,
+	"MaxRateChange":                 flagMaxRateChange,
+}
+
+// loadConfig resolves the config of cmd. Values are taken from, in increasing
+// priority, flag defaults, the --config toml file, explicitly set flags and
+// RMATIC_* environment variables. The source of every value is printed to the
+// output of cmd.
+// A non-empty tasks pins the task list of commands that run fixed tasks.
+func loadConfig(cmd *cobra.Command, tasks string) (*config.Config, error) {
+	configHome, err := cmd.Flags().GetString(flagHome)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "config home: %s\n", configHome)
+
+	cfg := &config.Config{}
+	sources := make(map[string]config.Source)
+
+	homeSource := config.SourceDefault
+	if cmd.Flags().Changed(flagHome) {
+		homeSource = config.SourceFlag
+	}
+	cfg.LogFilePath = filepath.Join(configHome, "log_data")
+	cfg.KeystorePath = filepath.Join(configHome, "keystore")
+	cfg.StorePath = filepath.Join(configHome, "state.json")
+	sources["LogFilePath"] = homeSource
+	sources["KeystorePath"] = homeSource
+	sources["StorePath"] = homeSource
+
+	fields := cfg.Fields()
+	for _, field := range fields {
+		flagName, exist := configFlags[field.Key]
+		if !exist {
+			continue
+		}
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil {
+			continue
+		}
+		*field.Value = f.DefValue
+		sources[field.Key] = config.SourceDefault
+	}
+
+	configPath, err := cmd.Flags().GetString(flagConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(configPath) != 0 {
+		fmt.Fprintf(cmd.OutOrStdout(), "config file: %s\n", configPath)
+		defined, err := config.LoadFile(configPath, cfg)
+		if err != nil {
+			return nil, fmt.Errorf("load config file %s failed: %w", configPath, err)
+		}
+		for key := range defined {
+			sources[key] = config.SourceFile
+		}
+	}
+
+	for _, field := range fields {
+		flagName, exist := configFlags[field.Key]
+		if !exist {
+			continue
+		}
+		f := cmd.Flags().Lookup(flagName)
+		if f == nil || !f.Changed {
+			continue
+		}
+		*field.Value = f.Value.String()
+		sources[field.Key] = config.SourceFlag
+	}
+
+	cfg.ApplyEnv(sources)
+
+	if f := cmd.Flags().Lookup(flagDryRun); f != nil {
+		cfg.DryRun, err = cmd.Flags().GetBool(flagDryRun)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "dry run: %t\n", cfg.DryRun)
+	}
+
+	if len(tasks) != 0 {
+		cfg.Tasks = tasks
+		sources["Tasks"] = config.SourceCommand
+	}
+
+	for _, field := range fields {
+		source, exist := sources[field.Key]
+		if !exist {
+			continue
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "config %s: %s (from %s)\n", field.Key, *field.Value, source)
+	}
+	for _, destination := range cfg.Destinations {
+		fmt.Fprintf(cmd.OutOrStdout(), "config destination %s: %s chain %s at %s (from %s)\n",
+			destination.Name, destination.StakePortalRateAddress, destination.ChainId, destination.RpcEndpoint, config.SourceFile)
+	}
+
+	return cfg, nil
+}
@@ -6,46 +6,91 @@ import (
 	"os"
 	"path/filepath"
 
+	gethKeystore "github.com/ethereum/go-ethereum/accounts/keystore"
 	ethCrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
-	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
-	"github.com/stafiprotocol/chainbridge/utils/keystore"
 )
 
 var flagKeystorePath = "keystore_path"
 var defaultKeystorePath        = filepath.Join(os.Getenv("HOME"), ".stafi/rmatic/keystore")
 
+var (
+	flagUnsafePlaintext = "unsafe-plaintext"
+	flagOut             = "out"
+)
+
 func exportAccountCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "export-account",
 		Args:  cobra.ExactArgs(0),
-		Short: "Export account",
+		Short: "Export account as a geth keystore json, or as a raw private key with --unsafe-plaintext",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			keystorePath, err := cmd.Flags().GetString(flagKeystorePath)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("keystore path: %s\n", keystorePath)
+			// keep stdout for the exported key
+			fmt.Fprintf(os.Stderr, "keystore path: %s\n", keystorePath)
 			accountStr, err := cmd.Flags().GetString(flagAccount)
 			if err != nil {
 				return err
 			}
+			unsafePlaintext, err := cmd.Flags().GetBool(flagUnsafePlaintext)
+			if err != nil {
+				return err
+			}
+			out, err := cmd.Flags().GetString(flagOut)
+			if err != nil {
+				return err
+			}
 
 			// load ssv account
-			ssvkpI, err := keystore.KeypairFromAddress(accountStr, keystore.EthChain, keystorePath, false)
+			ssvkp, err := loadKeypair(cmd, accountStr, keystorePath)
 			if err != nil {
 				return err
 			}
-			ssvkp, ok := ssvkpI.(*secp256k1.Keypair)
-			if !ok {
-				return fmt.Errorf("ssv keypair err")
+
+			var exported []byte
+			if unsafePlaintext {
+				exported = []byte(fmt.Sprintf("privateKey: %s\n", hex.EncodeToString(ethCrypto.FromECDSA(ssvkp.PrivateKey()))))
+			} else {
+				password, err := readNewPassword(cmd, "password for exported keystore:")
+				if err != nil {
+					return err
+				}
+				id, err := uuid.NewRandom()
+				if err != nil {
+					return err
+				}
+				key := &gethKeystore.Key{Id: id, Address: ssvkp.CommonAddress(), PrivateKey: ssvkp.PrivateKey()}
+				exported, err = gethKeystore.EncryptKey(key, string(password), gethKeystore.StandardScryptN, gethKeystore.StandardScryptP)
+				if err != nil {
+					return err
+				}
+				exported = append(exported, '\n')
 			}
 
-			fmt.Printf("privateKey: %s\n", hex.EncodeToString(ethCrypto.FromECDSA(ssvkp.PrivateKey())))
-			return nil
+			if len(out) == 0 {
+				_, err = os.Stdout.Write(exported)
+				return err
+			}
+			file, err := os.OpenFile(out, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0600)
+			if err != nil {
+				return err
+			}
+			if _, err := file.Write(exported); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
 		},
 	}
 	cmd.Flags().String(flagKeystorePath, defaultKeystorePath, "Keystore file path")
 	cmd.Flags().String(flagAccount, "", "Account hex address")
+	cmd.Flags().Bool(flagUnsafePlaintext, false, "Print the raw private key instead of an encrypted keystore json")
+	cmd.Flags().String(flagOut, "", "File to write the export to, created with mode 0600, stdout when empty")
+	cmd.Flags().String(flagNewPasswordFile, "", "File holding the password of the exported keystore json, prompted when empty")
+	addPasswordFlags(cmd)
 	return cmd
 }
@@ -51,21 +51,37 @@ func generateKeyFileByPrivateKey(keypath string) error {
 		return fmt.Errorf("could not generate secp256k1 keypair from given string: %s", err)
 	}
 
+	password := keystore.GetPassword("password for key:")
+	fp, err := writeKeyFile(keypath, kp, password)
+	if err != nil {
+		return err
+	}
+	logrus.WithFields(logrus.Fields{
+		"address": kp.Address(),
+		"file":    fp,
+	}).Info("key generated")
+
+	return nil
+}
+
+// writeKeyFile encrypts kp with password into a new key file of keypath and
+// returns its path, an existing key file is never overwritten.
+func writeKeyFile(keypath string, kp crypto.Keypair, password []byte) (string, error) {
 	fp, err := filepath.Abs(keypath + "/" + kp.Address() + ".key")
 	if err != nil {
-		return fmt.Errorf("invalid filepath: %s", err)
+		return "", fmt.Errorf("invalid filepath: %s", err)
 	}
 
 	if _, err := os.Stat(fp); err != nil {
 		err := os.MkdirAll(filepath.Dir(fp), 0700)
 		if err != nil {
-			return err
+			return "", err
 		}
 	}
 
 	file, err := os.OpenFile(filepath.Clean(fp), os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
-		return err
+		return "", err
 	}
 
 	defer func() {
@@ -75,15 +91,9 @@ func generateKeyFileByPrivateKey(keypath string) error {
 		}
 	}()
 
-	password := keystore.GetPassword("password for key:")
 	err = keystore.EncryptAndWriteToFile(file, kp, password)
 	if err != nil {
-		return fmt.Errorf("could not write key to file: %s", err)
+		return "", fmt.Errorf("could not write key to file: %s", err)
 	}
-	logrus.WithFields(logrus.Fields{
-		"address": kp.Address(),
-		"file":    fp,
-	}).Info("key generated")
-
-	return nil
+	return fp, nil
 }
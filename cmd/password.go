@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"rmatic-relay/pkg/utils"
+
+	"github.com/spf13/cobra"
+	"github.com/stafiprotocol/chainbridge/utils/crypto"
+	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+	"github.com/stafiprotocol/chainbridge/utils/keystore"
+)
+
+var (
+	flagPasswordFile = "password_file"
+	flagPasswordEnv  = "password_env"
+	flagPasswordFd   = "password_fd"
+)
+
+func addPasswordFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagPasswordFile, "", "File holding the keystore password, must not be world-readable")
+	cmd.Flags().String(flagPasswordEnv, "", "Name of the environment variable holding the keystore password")
+	cmd.Flags().Int(flagPasswordFd, -1, "Open file descriptor to read the keystore password from")
+}
+
+func passwordSource(cmd *cobra.Command) (*utils.PasswordSource, error) {
+	file, err := cmd.Flags().GetString(flagPasswordFile)
+	if err != nil {
+		return nil, err
+	}
+	env, err := cmd.Flags().GetString(flagPasswordEnv)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := cmd.Flags().GetInt(flagPasswordFd)
+	if err != nil {
+		return nil, err
+	}
+	return &utils.PasswordSource{File: file, Env: env, Fd: fd}, nil
+}
+
+// loadKeypair decrypts the keystore file of account with the password of the
+// password flags, or prompts for it when none is given.
+func loadKeypair(cmd *cobra.Command, account, keystorePath string) (*secp256k1.Keypair, error) {
+	source, err := passwordSource(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	var kpI crypto.Keypair
+	if source.IsSet() {
+		password, err := source.Read()
+		if err != nil {
+			return nil, err
+		}
+		kpI, err = keystore.ReadFromFileAndDecrypt(filepath.Join(keystorePath, account+".key"), password, string(crypto.Secp256k1Type))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		kpI, err = keystore.KeypairFromAddress(account, keystore.EthChain, keystorePath, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+	kp, ok := kpI.(*secp256k1.Keypair)
+	if !ok {
+		return nil, fmt.Errorf("keypair err")
+	}
+	return kp, nil
+}
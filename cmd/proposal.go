@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"rmatic-relay/bindings/StakePortalRate"
+	"rmatic-relay/pkg/config"
+	"rmatic-relay/shared"
+	"rmatic-relay/task"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagProposalId  = "id"
+	flagEra         = "era"
+	flagRate        = "rate"
+	flagFactor      = "factor"
+	flagVoters      = "voters"
+	flagDestination = "destination"
+)
+
+type voterStatus struct {
+	Voter    string `json:"voter"`
+	HasVoted bool   `json:"hasVoted"`
+}
+
+type proposalReport struct {
+	Destination   string        `json:"destination"`
+	ProposalId    string        `json:"proposalId"`
+	Era           string        `json:"era,omitempty"`
+	Rate          string        `json:"rate,omitempty"`
+	Factor        string        `json:"factor,omitempty"`
+	Block         uint64        `json:"block"`
+	Status        string        `json:"status"`
+	YesVotes      uint16        `json:"yesVotes"`
+	YesVotesTotal uint8         `json:"yesVotesTotal"`
+	Threshold     uint8         `json:"threshold"`
+	Voters        []voterStatus `json:"voters"`
+}
+
+func proposalCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proposal",
+		Short: "Inspect the voteRate proposals of StakePortalRate",
+	}
+	cmd.AddCommand(proposalShowCmd())
+	return cmd
+}
+
+func proposalShowCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "show",
+		Args:  cobra.ExactArgs(0),
+		Short: "Show a proposal by --id, or by the --era and --rate it is computed from",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := cmd.Flags().GetString(flagOutput)
+			if err != nil {
+				return err
+			}
+			if output != outputTable && output != outputJson {
+				return fmt.Errorf("unknown output: %s", output)
+			}
+			report, err := proposalFromFlags(cmd)
+			if err != nil {
+				return err
+			}
+			votersStr, err := cmd.Flags().GetString(flagVoters)
+			if err != nil {
+				return err
+			}
+
+			if output == outputJson {
+				// keep stdout parseable, the config sources go to stderr
+				cmd.SetOut(cmd.ErrOrStderr())
+			}
+			cfg, err := loadConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			destinationName, err := cmd.Flags().GetString(flagDestination)
+			if err != nil {
+				return err
+			}
+			destination, err := findDestination(cfg, destinationName)
+			if err != nil {
+				return err
+			}
+			if !common.IsHexAddress(destination.StakePortalRateAddress) {
+				return fmt.Errorf("stake portal rate not hex address: %s", destination.StakePortalRateAddress)
+			}
+			report.Destination = destination.Name
+			voters, err := parseVoters(cfg.Account, votersStr)
+			if err != nil {
+				return err
+			}
+
+			err = getProposalStatus(config.SplitEndpoints(destination.RpcEndpoint), common.HexToAddress(destination.StakePortalRateAddress), report, voters)
+			if err != nil {
+				return err
+			}
+
+			if output == outputJson {
+				bz, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(bz))
+				return nil
+			}
+			return printProposalTable(report)
+		},
+	}
+
+	cmd.Flags().String(flagProposalId, "", "Proposal id, 32 bytes hex")
+	cmd.Flags().Uint32(flagEra, 0, "Era of the proposal, used with --rate")
+	cmd.Flags().String(flagRate, "", "Rate of the proposal in wei, used with --era")
+	cmd.Flags().Int(flagFactor, 0, "Factor of the proposal, used with --era and --rate")
+	cmd.Flags().String(flagVoters, "", "Comma separated sub-accounts to check votes of, in addition to --account")
+	cmd.Flags().String(flagDestination, "", "Name of the destination in [[destinations]], the first one when empty")
+	cmd.Flags().String(flagOutput, outputTable, "Output format (table|json)")
+	cmd.Flags().String(flagConfig, defaultConfig, "Config file path, flags and RMATIC_* environment variables override its values")
+	cmd.Flags().String(flagHome, defaultHomePath, "Home path")
+	cmd.Flags().String(flagPolygonEndpoint, defaultPolygonEndpoint, "Rpc endpoints of polygon, comma separated in failover order")
+	cmd.Flags().String(flagStakePortalRate, defaultStakePortalRate, "Polygon stake portal rate contract address")
+	cmd.Flags().String(flagAccount, "", "Account hex string address")
+	return cmd
+}
+
+// proposalFromFlags resolves the proposal id from either --id or --era and --rate.
+func proposalFromFlags(cmd *cobra.Command) (*proposalReport, error) {
+	idStr, err := cmd.Flags().GetString(flagProposalId)
+	if err != nil {
+		return nil, err
+	}
+	rateStr, err := cmd.Flags().GetString(flagRate)
+	if err != nil {
+		return nil, err
+	}
+	byId := len(idStr) != 0
+	byEra := cmd.Flags().Changed(flagEra) || len(rateStr) != 0 || cmd.Flags().Changed(flagFactor)
+
+	switch {
+	case byId && byEra:
+		return nil, fmt.Errorf("--%s can not be used with --%s, --%s or --%s", flagProposalId, flagEra, flagRate, flagFactor)
+	case byId:
+		id, err := hexutil.Decode(idStr)
+		if err != nil || len(id) != common.HashLength {
+			return nil, fmt.Errorf("proposal id not 32 bytes hex: %s", idStr)
+		}
+		return &proposalReport{ProposalId: common.BytesToHash(id).String()}, nil
+	case byEra:
+		if !cmd.Flags().Changed(flagEra) || len(rateStr) == 0 {
+			return nil, fmt.Errorf("--%s and --%s are both required", flagEra, flagRate)
+		}
+		era, err := cmd.Flags().GetUint32(flagEra)
+		if err != nil {
+			return nil, err
+		}
+		factor, err := cmd.Flags().GetInt(flagFactor)
+		if err != nil {
+			return nil, err
+		}
+		rate, ok := new(big.Int).SetString(rateStr, 10)
+		if !ok || rate.Sign() <= 0 {
+			return nil, fmt.Errorf("rate not positive integer: %s", rateStr)
+		}
+		return &proposalReport{
+			ProposalId: task.GetProposalId(era, rate, factor).String(),
+			Era:        fmt.Sprint(era),
+			Rate:       rate.String(),
+			Factor:     fmt.Sprint(factor),
+		}, nil
+	default:
+		return nil, fmt.Errorf("either --%s or --%s and --%s is required", flagProposalId, flagEra, flagRate)
+	}
+}
+
+// findDestination returns the sync destination called name, or the first one when name is empty.
+func findDestination(cfg *config.Config, name string) (*config.Destination, error) {
+	destinations := cfg.SyncDestinations()
+	if len(name) == 0 {
+		return &destinations[0], nil
+	}
+	for i := range destinations {
+		if destinations[i].Name == name {
+			return &destinations[i], nil
+		}
+	}
+	return nil, fmt.Errorf("destination %s not found", name)
+}
+
+// parseVoters returns the configured account followed by the extra voters, deduplicated.
+func parseVoters(account, voters string) ([]common.Address, error) {
+	ret := make([]common.Address, 0)
+	seen := make(map[common.Address]bool)
+	for _, voter := range append([]string{account}, strings.Split(voters, ",")...) {
+		voter = strings.TrimSpace(voter)
+		if len(voter) == 0 {
+			continue
+		}
+		if !common.IsHexAddress(voter) {
+			return nil, fmt.Errorf("voter not hex address: %s", voter)
+		}
+		addr := common.HexToAddress(voter)
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		ret = append(ret, addr)
+	}
+	return ret, nil
+}
+
+// getProposalStatus fills report with the proposal state and the votes of
+// voters, every read pinned to the same block.
+func getProposalStatus(endpoints []string, stakePortalRateAddress common.Address, report *proposalReport, voters []common.Address) error {
+	client, err := shared.NewClient(endpoints, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	block, err := client.LatestBlock()
+	if err != nil {
+		return err
+	}
+	stakePortalRate, err := stake_portal_rate.NewStakePortalRate(stakePortalRateAddress, client)
+	if err != nil {
+		return err
+	}
+	callOpts := &bind.CallOpts{BlockNumber: block, Context: context.Background()}
+	proposalId := common.HexToHash(report.ProposalId)
+
+	proposal, err := stakePortalRate.Proposals(callOpts, proposalId)
+	if err != nil {
+		return fmt.Errorf("Proposals failed: %w", err)
+	}
+	threshold, err := stakePortalRate.Threshold(callOpts)
+	if err != nil {
+		return fmt.Errorf("Threshold failed: %w", err)
+	}
+	report.Block = block.Uint64()
+	report.Status = task.ProposalStatusName(proposal.Status)
+	report.YesVotes = proposal.YesVotes
+	report.YesVotesTotal = proposal.YesVotesTotal
+	report.Threshold = threshold
+
+	report.Voters = make([]voterStatus, 0, len(voters))
+	for _, voter := range voters {
+		hasVoted, err := stakePortalRate.HasVoted(callOpts, proposalId, voter)
+		if err != nil {
+			return fmt.Errorf("HasVoted %s failed: %w", voter.String(), err)
+		}
+		report.Voters = append(report.Voters, voterStatus{Voter: voter.String(), HasVoted: hasVoted})
+	}
+	return nil
+}
+
+func printProposalTable(report *proposalReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "destination\t%s\n", report.Destination)
+	fmt.Fprintf(w, "proposalId\t%s\n", report.ProposalId)
+	if len(report.Era) != 0 {
+		fmt.Fprintf(w, "era\t%s\n", report.Era)
+		fmt.Fprintf(w, "rate\t%s\n", report.Rate)
+		fmt.Fprintf(w, "factor\t%s\n", report.Factor)
+	}
+	fmt.Fprintf(w, "block\t%d\n", report.Block)
+	fmt.Fprintf(w, "status\t%s\n", report.Status)
+	fmt.Fprintf(w, "yesVotes\t%016b\n", report.YesVotes)
+	fmt.Fprintf(w, "yesVotesTotal\t%d\n", report.YesVotesTotal)
+	fmt.Fprintf(w, "threshold\t%d\n", report.Threshold)
+	if len(report.Voters) != 0 {
+		fmt.Fprintf(w, "\t\n")
+		fmt.Fprintf(w, "VOTER\tVOTED\n")
+		for _, voter := range report.Voters {
+			fmt.Fprintf(w, "%s\t%t\n", voter.Voter, voter.HasVoted)
+		}
+	}
+	return w.Flush()
+}
@@ -32,8 +32,13 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(
 		genAccountCmd(),
 		exportAccountCmd(),
+		accountCmd(),
 		startCmd(),
 		syncRateCmd(),
+		runCmd(),
+		configCmd(),
+		statusCmd(),
+		proposalCmd(),
 		versionCmd(),
 	)
 	return rootCmd
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"rmatic-relay/pkg/api"
+	"rmatic-relay/pkg/config"
+	"rmatic-relay/pkg/log"
+	"rmatic-relay/pkg/utils"
+	"rmatic-relay/shared"
+	"rmatic-relay/task"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagTasks = "tasks"
+
+	defaultTasks = "new_era,sync_rate"
+)
+
+func runCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run",
+		Args:  cobra.ExactArgs(0),
+		Short: "Run a set of tasks in one process",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTasks(cmd, "")
+		},
+	}
+
+	cmd.Flags().String(flagTasks, defaultTasks, "Comma separated tasks to run (new_era|sync_rate)")
+	addRunFlags(cmd)
+	return cmd
+}
+
+// addRunFlags adds the flags of the config values and the signer shared by the
+// commands running tasks.
+func addRunFlags(cmd *cobra.Command) {
+	cmd.Flags().String(flagConfig, defaultConfig, "Config file path, flags and RMATIC_* environment variables override its values")
+	cmd.Flags().String(flagHome, defaultHomePath, "Home path")
+	cmd.Flags().String(flagEthEndpoint, defaultEthEndpoint, "Rpc endpoints of eth execution layer, comma separated in failover order")
+	cmd.Flags().String(flagPolygonEndpoint, defaultPolygonEndpoint, "Rpc endpoints of polygon, comma separated in failover order")
+	cmd.Flags().String(flagAccount, "", "Account hex string address")
+	cmd.Flags().String(flagSignerEndpoint, "", "External signer url (Clef, web3signer) signing with eth_signTransaction instead of the keystore")
+	cmd.Flags().String(flagGasLimit, defaultGasLimit, "Gas limit")
+	cmd.Flags().String(flagMaxGasPrice, defaultMaxGasPrice, "Max gas price")
+	cmd.Flags().String(flagEthGasPriceMode, defaultGasPriceMode, "Gas price mode of eth (dynamic|legacy)")
+	cmd.Flags().String(flagReplaceTxBlocks, defaultReplaceTxBlocks, "Replace a pending tx with a higher fee after this many blocks")
+	cmd.Flags().String(flagEthConfirmBlocks, defaultEthConfirmBlocks, "Confirmation depth of reads and txs on eth, a block count or finalized")
+	cmd.Flags().String(flagPolygonConfirmBlocks, defaultPolygonConfirmBlocks, "Confirmation depth of reads and txs on polygon, a block count or finalized")
+	cmd.Flags().String(flagPolygonGasPriceMode, defaultGasPriceMode, "Gas price mode of polygon (dynamic|legacy)")
+	cmd.Flags().String(flagStakeManager, defaultStakeManger, "Stake manager contract address")
+	cmd.Flags().String(flagStakePortalRate, defaultStakePortalRate, "Polygon stake portal rate contract address")
+	cmd.Flags().String(flagLogLevel, defaultLogLevel, "The logging level (trace|debug|info|warn|error|fatal|panic)")
+	cmd.Flags().String(flagMetricsAddr, "", "Listen address of the prometheus metrics and /healthz, /readyz, e.g. :9100, disabled when empty")
+	cmd.Flags().String(flagEthMinBalance, defaultMinBalance, "Readiness fails when the signer balance on eth in wei is below this")
+	cmd.Flags().String(flagPolygonMinBalance, defaultMinBalance, "Readiness fails when the signer balance on polygon in wei is below this")
+	cmd.Flags().String(flagReadyTickIntervals, defaultReadyIntervals, "Readiness fails when a task has no successful tick, or a destination no successful sync, for this many ticker intervals")
+	cmd.Flags().String(flagMaxRateChange, defaultMaxRateChange, "Refuse to vote a polygon rate changing by more than this fraction, e.g. 0.01, 0 means only the contract limit")
+	cmd.Flags().Bool(flagDryRun, false, "Run the normal detection logic but log the txs instead of sending them")
+	addPasswordFlags(cmd)
+}
+
+// runTasks loads and validates the config, sets up the signer once and
+// runs every configured task until a shutdown signal is received. A non-empty
+// tasks overrides the configured task list.
+func runTasks(cmd *cobra.Command, tasks string) error {
+	cfg, err := loadConfig(cmd, tasks)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	taskTypes, err := utils.ParseTaskTypes(cfg.Tasks)
+	if err != nil {
+		return err
+	}
+
+	// check log level
+	logLevel, err := logrus.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("log level: %s\n", cfg.LogLevel)
+	logrus.SetLevel(logLevel)
+
+	err = log.InitLogFile(cfg.LogFilePath)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("cfg %+v", cfg)
+
+	ctx := utils.ShutdownListener()
+	signer, err := newSigner(cmd, cfg)
+	if err != nil {
+		return err
+	}
+
+	logrus.Info("task starting...")
+	r, err := task.NewRunner(cfg, signer, taskTypes)
+	if err != nil {
+		return err
+	}
+	err = r.Start()
+	if err != nil {
+		logrus.Errorf("task start err: %s", err)
+		return err
+	}
+	defer func() {
+		logrus.Infof("shutting down task ...")
+		r.Stop()
+	}()
+
+	if len(cfg.MetricsListenAddr) != 0 {
+		utils.SafeGo(func() {
+			if err := api.Serve(ctx, cfg.MetricsListenAddr, r.Ready); err != nil {
+				logrus.Errorf("api server stopped: %s", err)
+			}
+		})
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+// newSigner connects the external signer of the account, or unlocks its keystore.
+func newSigner(cmd *cobra.Command, cfg *config.Config) (shared.Signer, error) {
+	if len(cfg.SignerEndpoint) != 0 {
+		logrus.Infof("sign with external signer %s", cfg.SignerEndpoint)
+		return shared.NewExternalSigner(cfg.SignerEndpoint, common.HexToAddress(cfg.Account))
+	}
+	kp, err := loadKeypair(cmd, cfg.Account, cfg.KeystorePath)
+	if err != nil {
+		return nil, err
+	}
+	return shared.NewKeystoreSigner(kp), nil
+}
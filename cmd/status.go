@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"rmatic-relay/bindings/StakeManager"
+	"rmatic-relay/bindings/StakePortalRate"
+	"rmatic-relay/pkg/config"
+	"rmatic-relay/shared"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/spf13/cobra"
+)
+
+var (
+	flagOutput = "output"
+
+	outputTable = "table"
+	outputJson  = "json"
+)
+
+type poolStatus struct {
+	Pool   string `json:"pool"`
+	Bond   string `json:"bond"`
+	Unbond string `json:"unbond"`
+}
+
+type ethStatus struct {
+	ChainId           string       `json:"chainId"`
+	Block             uint64       `json:"block"`
+	StakeManager      string       `json:"stakeManager"`
+	CurrentEra        string       `json:"currentEra"`
+	LatestEra         string       `json:"latestEra"`
+	EraSeconds        string       `json:"eraSeconds"`
+	EraOffset         string       `json:"eraOffset"`
+	Rate              string       `json:"rate"`
+	LatestEraRate     string       `json:"latestEraRate"`
+	RateChangeLimit   string       `json:"rateChangeLimit"`
+	TotalRTokenSupply string       `json:"totalRTokenSupply"`
+	Pools             []poolStatus `json:"pools"`
+}
+
+type destinationStatus struct {
+	Name            string `json:"name"`
+	ChainId         string `json:"chainId"`
+	Block           uint64 `json:"block"`
+	StakePortalRate string `json:"stakePortalRate"`
+	Rate            string `json:"rate"`
+	Threshold       uint8  `json:"threshold"`
+	RateChangeLimit string `json:"rateChangeLimit"`
+}
+
+type statusReport struct {
+	Ethereum     ethStatus           `json:"ethereum"`
+	Destinations []destinationStatus `json:"destinations"`
+}
+
+func statusCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "status",
+		Args:  cobra.ExactArgs(0),
+		Short: "Show the protocol state of StakeManager and the StakePortalRate of every destination",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			output, err := cmd.Flags().GetString(flagOutput)
+			if err != nil {
+				return err
+			}
+			if output != outputTable && output != outputJson {
+				return fmt.Errorf("unknown output: %s", output)
+			}
+			if output == outputJson {
+				// keep stdout parseable, the config sources go to stderr
+				cmd.SetOut(cmd.ErrOrStderr())
+			}
+			cfg, err := loadConfig(cmd, "")
+			if err != nil {
+				return err
+			}
+			if !common.IsHexAddress(cfg.StakeMangerAddress) {
+				return fmt.Errorf("stake manager not hex address: %s", cfg.StakeMangerAddress)
+			}
+
+			report := statusReport{}
+			ethStatus, err := getEthStatus(config.SplitEndpoints(cfg.EthRpcEndpoint), common.HexToAddress(cfg.StakeMangerAddress))
+			if err != nil {
+				return err
+			}
+			report.Ethereum = *ethStatus
+
+			// destinations are optional
+			report.Destinations = make([]destinationStatus, 0)
+			for _, destination := range cfg.SyncDestinations() {
+				if len(destination.RpcEndpoint) == 0 || len(destination.StakePortalRateAddress) == 0 {
+					continue
+				}
+				if !common.IsHexAddress(destination.StakePortalRateAddress) {
+					return fmt.Errorf("destination %s stake portal rate not hex address: %s", destination.Name, destination.StakePortalRateAddress)
+				}
+				status, err := getDestinationStatus(destination.Name, config.SplitEndpoints(destination.RpcEndpoint), common.HexToAddress(destination.StakePortalRateAddress))
+				if err != nil {
+					return fmt.Errorf("destination %s: %w", destination.Name, err)
+				}
+				report.Destinations = append(report.Destinations, *status)
+			}
+
+			if output == outputJson {
+				bz, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(bz))
+				return nil
+			}
+			return printStatusTable(&report)
+		},
+	}
+
+	cmd.Flags().String(flagOutput, outputTable, "Output format (table|json)")
+	cmd.Flags().String(flagConfig, defaultConfig, "Config file path, flags and RMATIC_* environment variables override its values")
+	cmd.Flags().String(flagHome, defaultHomePath, "Home path")
+	cmd.Flags().String(flagEthEndpoint, defaultEthEndpoint, "Rpc endpoints of eth execution layer, comma separated in failover order")
+	cmd.Flags().String(flagPolygonEndpoint, defaultPolygonEndpoint, "Rpc endpoints of polygon, comma separated in failover order")
+	cmd.Flags().String(flagStakeManager, defaultStakeManger, "Stake manager contract address")
+	cmd.Flags().String(flagStakePortalRate, defaultStakePortalRate, "Polygon stake portal rate contract address")
+	return cmd
+}
+
+// getEthStatus reads the StakeManager state, every read pinned to the same block.
+func getEthStatus(endpoints []string, stakeManagerAddress common.Address) (*ethStatus, error) {
+	client, err := shared.NewClient(endpoints, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	block, err := client.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	stakeManager, err := stake_manager.NewStakeManager(stakeManagerAddress, client)
+	if err != nil {
+		return nil, err
+	}
+	callOpts := &bind.CallOpts{BlockNumber: block, Context: context.Background()}
+
+	status := &ethStatus{
+		ChainId:      client.ChainID().String(),
+		Block:        block.Uint64(),
+		StakeManager: stakeManagerAddress.String(),
+	}
+	reads := []struct {
+		name  string
+		call  func(*bind.CallOpts) (*big.Int, error)
+		value *string
+	}{
+		{"CurrentEra", stakeManager.CurrentEra, &status.CurrentEra},
+		{"LatestEra", stakeManager.LatestEra, &status.LatestEra},
+		{"EraSeconds", stakeManager.EraSeconds, &status.EraSeconds},
+		{"EraOffset", stakeManager.EraOffset, &status.EraOffset},
+		{"GetRate", stakeManager.GetRate, &status.Rate},
+		{"RateChangeLimit", stakeManager.RateChangeLimit, &status.RateChangeLimit},
+		{"TotalRTokenSupply", stakeManager.TotalRTokenSupply, &status.TotalRTokenSupply},
+	}
+	for _, read := range reads {
+		value, err := read.call(callOpts)
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %w", read.name, err)
+		}
+		*read.value = value.String()
+	}
+
+	latestEra, _ := new(big.Int).SetString(status.LatestEra, 10)
+	eraRate, err := stakeManager.EraRate(callOpts, latestEra)
+	if err != nil {
+		return nil, fmt.Errorf("EraRate failed: %w", err)
+	}
+	status.LatestEraRate = eraRate.String()
+
+	pools, err := stakeManager.GetBondedPools(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("GetBondedPools failed: %w", err)
+	}
+	status.Pools = make([]poolStatus, 0, len(pools))
+	for _, pool := range pools {
+		poolInfo, err := stakeManager.PoolInfoOf(callOpts, pool)
+		if err != nil {
+			return nil, fmt.Errorf("PoolInfoOf %s failed: %w", pool.String(), err)
+		}
+		status.Pools = append(status.Pools, poolStatus{
+			Pool:   pool.String(),
+			Bond:   poolInfo.Bond.String(),
+			Unbond: poolInfo.Unbond.String(),
+		})
+	}
+	return status, nil
+}
+
+// getDestinationStatus reads the StakePortalRate state, every read pinned to the same block.
+func getDestinationStatus(name string, endpoints []string, stakePortalRateAddress common.Address) (*destinationStatus, error) {
+	client, err := shared.NewClient(endpoints, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	block, err := client.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	stakePortalRate, err := stake_portal_rate.NewStakePortalRate(stakePortalRateAddress, client)
+	if err != nil {
+		return nil, err
+	}
+	callOpts := &bind.CallOpts{BlockNumber: block, Context: context.Background()}
+
+	rate, err := stakePortalRate.GetRate(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("GetRate failed: %w", err)
+	}
+	threshold, err := stakePortalRate.Threshold(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("Threshold failed: %w", err)
+	}
+	rateChangeLimit, err := stakePortalRate.RateChangeLimit(callOpts)
+	if err != nil {
+		return nil, fmt.Errorf("RateChangeLimit failed: %w", err)
+	}
+	return &destinationStatus{
+		Name:            name,
+		ChainId:         client.ChainID().String(),
+		Block:           block.Uint64(),
+		StakePortalRate: stakePortalRateAddress.String(),
+		Rate:            rate.String(),
+		Threshold:       threshold,
+		RateChangeLimit: rateChangeLimit.String(),
+	}, nil
+}
+
+func printStatusTable(report *statusReport) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	eth := report.Ethereum
+	fmt.Fprintf(w, "ETHEREUM\t\n")
+	fmt.Fprintf(w, "chainId\t%s\n", eth.ChainId)
+	fmt.Fprintf(w, "block\t%d\n", eth.Block)
+	fmt.Fprintf(w, "stakeManager\t%s\n", eth.StakeManager)
+	fmt.Fprintf(w, "currentEra\t%s\n", eth.CurrentEra)
+	fmt.Fprintf(w, "latestEra\t%s\n", eth.LatestEra)
+	fmt.Fprintf(w, "eraSeconds\t%s\n", eth.EraSeconds)
+	fmt.Fprintf(w, "eraOffset\t%s\n", eth.EraOffset)
+	fmt.Fprintf(w, "rate\t%s\n", eth.Rate)
+	fmt.Fprintf(w, "eraRate(%s)\t%s\n", eth.LatestEra, eth.LatestEraRate)
+	fmt.Fprintf(w, "rateChangeLimit\t%s\n", eth.RateChangeLimit)
+	fmt.Fprintf(w, "totalRTokenSupply\t%s\n", eth.TotalRTokenSupply)
+	fmt.Fprintf(w, "\t\n")
+	fmt.Fprintf(w, "POOL\tBOND\tUNBOND\n")
+	for _, pool := range eth.Pools {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", pool.Pool, pool.Bond, pool.Unbond)
+	}
+
+	for _, destination := range report.Destinations {
+		fmt.Fprintf(w, "\t\n")
+		fmt.Fprintf(w, "%s\t\n", strings.ToUpper(destination.Name))
+		fmt.Fprintf(w, "chainId\t%s\n", destination.ChainId)
+		fmt.Fprintf(w, "block\t%d\n", destination.Block)
+		fmt.Fprintf(w, "stakePortalRate\t%s\n", destination.StakePortalRate)
+		fmt.Fprintf(w, "rate\t%s\n", destination.Rate)
+		fmt.Fprintf(w, "threshold\t%d\n", destination.Threshold)
+		fmt.Fprintf(w, "rateChangeLimit\t%s\n", destination.RateChangeLimit)
+	}
+	return w.Flush()
+}
@@ -6,28 +6,195 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
+// EnvPrefix is the prefix of the environment variables that override config values.
+const EnvPrefix = "RMATIC_"
+
+// Source tells where an effective config value came from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceFlag    Source = "flag"
+	SourceEnv     Source = "env"
+	SourceCommand Source = "command"
+)
+
+// ConfirmFinalized confirms at the finalized block instead of a block count.
+const ConfirmFinalized = "finalized"
+
+// gas price modes of a chain
+const (
+	GasPriceModeDynamic = "dynamic"
+	GasPriceModeLegacy  = "legacy"
+)
+
 type Config struct {
+	Tasks              string
 	EthRpcEndpoint     string
 	PolygonRpcEndpoint string
 	Account            string
 	GasLimit           string
 	MaxGasPrice        string
+	// SignerEndpoint is an external signer holding the key of Account, such
+	// as Clef or web3signer, used instead of the keystore when set
+	SignerEndpoint string
+
+	EthGasPriceMode     string
+	PolygonGasPriceMode string
+	// pending txs are replaced with a higher fee after this many blocks
+	ReplaceTxBlocks string
+	// reads and txs are confirmed this many blocks below the head, or at the
+	// finalized block when "finalized"
+	EthConfirmBlocks     string
+	PolygonConfirmBlocks string
 
 	StakeMangerAddress            string
 	PolygonStakePortalRateAddress string
 
+	LogLevel string
+	// MetricsListenAddr serves the prometheus metrics and the health
+	// endpoints, disabled when empty
+	MetricsListenAddr string
+	// readiness fails when a signer balance in wei is below these
+	EthMinBalance     string
+	PolygonMinBalance string
+	// readiness fails when a task has no successful tick for this many intervals
+	ReadyTickIntervals string
+	// the relative rate change voted on polygon is refused above this fraction,
+	// on top of the contract RateChangeLimit, 0 means only the contract limit
+	MaxRateChange string
+
+	// Destinations are the chains the sync_rate task votes the rate to, only
+	// set by the config file. When empty, the Polygon* values make the single
+	// polygon destination.
+	Destinations []Destination
+
+	// DryRun logs the txs instead of sending them, only set by flag
+	DryRun bool `toml:"-"`
+
 	//read from config
 	LogFilePath  string
 	KeystorePath string
+	// StorePath is the state file recording eras, votes and sent txs
+	StorePath string
+}
+
+// Destination is a chain running a StakePortalRate deployment, configured
+// with a [[destinations]] table.
+type Destination struct {
+	Name                   string
+	RpcEndpoint            string
+	StakePortalRateAddress string
+	// ChainId must match the chain of RpcEndpoint, required in [[destinations]]
+	ChainId string
+	// empty gas settings fall back to the top level ones, GasPriceMode to
+	// PolygonGasPriceMode
+	GasLimit     string
+	MaxGasPrice  string
+	GasPriceMode string
+	// empty falls back to PolygonConfirmBlocks
+	ConfirmBlocks string
+	// readiness fails when the signer balance in wei is below this, empty
+	// falls back to PolygonMinBalance
+	MinBalance string
+}
+
+// SyncDestinations returns the destinations of the sync_rate task, with the
+// empty settings filled from the top level ones.
+func (cfg *Config) SyncDestinations() []Destination {
+	if len(cfg.Destinations) == 0 {
+		return []Destination{{
+			Name:                   "polygon",
+			RpcEndpoint:            cfg.PolygonRpcEndpoint,
+			StakePortalRateAddress: cfg.PolygonStakePortalRateAddress,
+			GasLimit:               cfg.GasLimit,
+			MaxGasPrice:            cfg.MaxGasPrice,
+			GasPriceMode:           cfg.PolygonGasPriceMode,
+			ConfirmBlocks:          cfg.PolygonConfirmBlocks,
+			MinBalance:             cfg.PolygonMinBalance,
+		}}
+	}
+
+	destinations := make([]Destination, 0, len(cfg.Destinations))
+	for _, destination := range cfg.Destinations {
+		if len(destination.GasLimit) == 0 {
+			destination.GasLimit = cfg.GasLimit
+		}
+		if len(destination.MaxGasPrice) == 0 {
+			destination.MaxGasPrice = cfg.MaxGasPrice
+		}
+		if len(destination.GasPriceMode) == 0 {
+			destination.GasPriceMode = cfg.PolygonGasPriceMode
+		}
+		if len(destination.ConfirmBlocks) == 0 {
+			destination.ConfirmBlocks = cfg.PolygonConfirmBlocks
+		}
+		if len(destination.MinBalance) == 0 {
+			destination.MinBalance = cfg.PolygonMinBalance
+		}
+		destinations = append(destinations, destination)
+	}
+	return destinations
+}
+
+// Field binds a string config value to its toml key and environment variable.
+type Field struct {
+	Key   string
+	Env   string
+	Value *string
+}
+
+// Fields returns every value of cfg that can be set from the config file,
+// a flag or the environment, in a stable order.
+func (cfg *Config) Fields() []Field {
+	return []Field{
+		{Key: "Tasks", Env: EnvPrefix + "TASKS", Value: &cfg.Tasks},
+		{Key: "EthRpcEndpoint", Env: EnvPrefix + "ETH_RPC_ENDPOINT", Value: &cfg.EthRpcEndpoint},
+		{Key: "PolygonRpcEndpoint", Env: EnvPrefix + "POLYGON_RPC_ENDPOINT", Value: &cfg.PolygonRpcEndpoint},
+		{Key: "Account", Env: EnvPrefix + "ACCOUNT", Value: &cfg.Account},
+		{Key: "SignerEndpoint", Env: EnvPrefix + "SIGNER_ENDPOINT", Value: &cfg.SignerEndpoint},
+		{Key: "GasLimit", Env: EnvPrefix + "GAS_LIMIT", Value: &cfg.GasLimit},
+		{Key: "MaxGasPrice", Env: EnvPrefix + "MAX_GAS_PRICE", Value: &cfg.MaxGasPrice},
+		{Key: "EthGasPriceMode", Env: EnvPrefix + "ETH_GAS_PRICE_MODE", Value: &cfg.EthGasPriceMode},
+		{Key: "PolygonGasPriceMode", Env: EnvPrefix + "POLYGON_GAS_PRICE_MODE", Value: &cfg.PolygonGasPriceMode},
+		{Key: "ReplaceTxBlocks", Env: EnvPrefix + "REPLACE_TX_BLOCKS", Value: &cfg.ReplaceTxBlocks},
+		{Key: "EthConfirmBlocks", Env: EnvPrefix + "ETH_CONFIRM_BLOCKS", Value: &cfg.EthConfirmBlocks},
+		{Key: "PolygonConfirmBlocks", Env: EnvPrefix + "POLYGON_CONFIRM_BLOCKS", Value: &cfg.PolygonConfirmBlocks},
+		{Key: "StakeMangerAddress", Env: EnvPrefix + "STAKE_MANAGER_ADDRESS", Value: &cfg.StakeMangerAddress},
+		{Key: "PolygonStakePortalRateAddress", Env: EnvPrefix + "POLYGON_STAKE_PORTAL_RATE_ADDRESS", Value: &cfg.PolygonStakePortalRateAddress},
+		{Key: "MetricsListenAddr", Env: EnvPrefix + "METRICS_LISTEN_ADDR", Value: &cfg.MetricsListenAddr},
+		{Key: "EthMinBalance", Env: EnvPrefix + "ETH_MIN_BALANCE", Value: &cfg.EthMinBalance},
+		{Key: "PolygonMinBalance", Env: EnvPrefix + "POLYGON_MIN_BALANCE", Value: &cfg.PolygonMinBalance},
+		{Key: "ReadyTickIntervals", Env: EnvPrefix + "READY_TICK_INTERVALS", Value: &cfg.ReadyTickIntervals},
+		{Key: "MaxRateChange", Env: EnvPrefix + "MAX_RATE_CHANGE", Value: &cfg.MaxRateChange},
+		{Key: "LogLevel", Env: EnvPrefix + "LOG_LEVEL", Value: &cfg.LogLevel},
+		{Key: "LogFilePath", Env: EnvPrefix + "LOG_FILE_PATH", Value: &cfg.LogFilePath},
+		{Key: "KeystorePath", Env: EnvPrefix + "KEYSTORE_PATH", Value: &cfg.KeystorePath},
+		{Key: "StorePath", Env: EnvPrefix + "STORE_PATH", Value: &cfg.StorePath},
+	}
+}
+
+// SplitEndpoints splits a comma separated endpoint list, keeping its order.
+func SplitEndpoints(endpoints string) []string {
+	list := make([]string, 0)
+	for _, endpoint := range strings.Split(endpoints, ",") {
+		endpoint = strings.TrimSpace(endpoint)
+		if len(endpoint) != 0 {
+			list = append(list, endpoint)
+		}
+	}
+	return list
 }
 
 func Load(configFilePath string) (*Config, error) {
 	var cfg = Config{}
-	if err := loadSysConfig(configFilePath, &cfg); err != nil {
+	if _, err := loadSysConfig(configFilePath, &cfg); err != nil {
 		return nil, err
 	}
 	if len(cfg.LogFilePath) == 0 {
@@ -37,14 +204,42 @@ func Load(configFilePath string) (*Config, error) {
 	return &cfg, nil
 }
 
-func loadSysConfig(path string, config *Config) error {
-	_, err := os.Open(path)
+// LoadFile decodes the config file at path on top of the values already in cfg
+// and returns the keys defined by the file.
+func LoadFile(path string, cfg *Config) (map[string]bool, error) {
+	md, err := loadSysConfig(path, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defined := make(map[string]bool)
+	for _, field := range cfg.Fields() {
+		if md.IsDefined(field.Key) {
+			defined[field.Key] = true
+		}
+	}
+	return defined, nil
+}
+
+// ApplyEnv overrides cfg with the non-empty RMATIC_* environment variables
+// and records them in sources.
+func (cfg *Config) ApplyEnv(sources map[string]Source) {
+	for _, field := range cfg.Fields() {
+		if value, exist := os.LookupEnv(field.Env); exist && len(value) != 0 {
+			*field.Value = value
+			sources[field.Key] = SourceEnv
+		}
+	}
+}
+
+func loadSysConfig(path string, config *Config) (toml.MetaData, error) {
+	_, err := os.Stat(path)
 	if err != nil {
-		return err
+		return toml.MetaData{}, err
 	}
-	if _, err := toml.DecodeFile(path, config); err != nil {
-		return err
+	md, err := toml.DecodeFile(path, config)
+	if err != nil {
+		return toml.MetaData{}, err
 	}
 	fmt.Println("load config success")
-	return nil
+	return md, nil
 }
@@ -0,0 +1,143 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"rmatic-relay/pkg/config"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	keystorePath := t.TempDir()
+	account := "0x8A0B7a1D4EDdEB5E5BF5B9D8Bf2E3bC5c5C1a5D2"
+	if err := os.WriteFile(filepath.Join(keystorePath, account+".key"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{
+		Tasks:                         "new_era,sync_rate",
+		EthRpcEndpoint:                "https://eth.example.org",
+		PolygonRpcEndpoint:            "wss://polygon.example.org",
+		Account:                       account,
+		GasLimit:                      "2000000",
+		MaxGasPrice:                   "150000000000",
+		ReplaceTxBlocks:               "20",
+		EthConfirmBlocks:              "3",
+		PolygonConfirmBlocks:          "finalized",
+		EthMinBalance:                 "0",
+		PolygonMinBalance:             "1000000000000000000",
+		ReadyTickIntervals:            "10",
+		MaxRateChange:                 "0.01",
+		StakeMangerAddress:            "0x1111111111111111111111111111111111111111",
+		PolygonStakePortalRateAddress: "0x2222222222222222222222222222222222222222",
+		LogLevel:                      "info",
+		KeystorePath:                  keystorePath,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.EthRpcEndpoint = "eth.example.org"
+	cfg.StakeMangerAddress = "0x0000000000000000000000000000000000000000"
+	cfg.PolygonStakePortalRateAddress = "0x123"
+	cfg.GasLimit = "100"
+	cfg.MaxGasPrice = "abc"
+	cfg.Account = "0x3333333333333333333333333333333333333333"
+	err := cfg.Validate()
+
+	var validationErr config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	keys := make(map[string]bool)
+	for _, fieldErr := range validationErr {
+		keys[fieldErr.Key] = true
+	}
+	for _, key := range []string{"EthRpcEndpoint", "StakeMangerAddress", "PolygonStakePortalRateAddress", "GasLimit", "MaxGasPrice", "Account"} {
+		if !keys[key] {
+			t.Errorf("missing error for %s in %v", key, err)
+		}
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	dir := t.TempDir()
+	account := "0x8A0B7a1D4EDdEB5E5BF5B9D8Bf2E3bC5c5C1a5D2"
+	if err := os.WriteFile(filepath.Join(dir, account+".key"), []byte("{}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config.toml")
+	err := os.WriteFile(configPath, []byte(`
+Tasks = "sync_rate"
+EthRpcEndpoint = "https://eth.example.org"
+Account = "`+account+`"
+GasLimit = "2000000"
+MaxGasPrice = "150000000000"
+ReplaceTxBlocks = "20"
+EthConfirmBlocks = "3"
+PolygonConfirmBlocks = "64"
+EthMinBalance = "0"
+PolygonMinBalance = "1000"
+PolygonGasPriceMode = "dynamic"
+ReadyTickIntervals = "10"
+MaxRateChange = "0"
+StakeMangerAddress = "0x1111111111111111111111111111111111111111"
+LogLevel = "info"
+
+[[destinations]]
+Name = "polygon"
+RpcEndpoint = "https://polygon.example.org"
+StakePortalRateAddress = "0x2222222222222222222222222222222222222222"
+ChainId = "137"
+
+[[destinations]]
+Name = "other"
+RpcEndpoint = "https://other.example.org"
+StakePortalRateAddress = "0x3333333333333333333333333333333333333333"
+ChainId = "10"
+GasLimit = "500000"
+GasPriceMode = "legacy"
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Config{KeystorePath: dir}
+	if _, err := config.LoadFile(configPath, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	destinations := cfg.SyncDestinations()
+	if len(destinations) != 2 {
+		t.Fatalf("expected 2 destinations, got %d", len(destinations))
+	}
+	if destinations[0].GasLimit != "2000000" || destinations[1].GasLimit != "500000" {
+		t.Errorf("unexpected gas limits %s, %s", destinations[0].GasLimit, destinations[1].GasLimit)
+	}
+	if destinations[0].GasPriceMode != "dynamic" || destinations[1].GasPriceMode != "legacy" {
+		t.Errorf("unexpected gas price modes %s, %s", destinations[0].GasPriceMode, destinations[1].GasPriceMode)
+	}
+	if destinations[0].MinBalance != "1000" || destinations[1].MinBalance != "1000" {
+		t.Errorf("unexpected min balances %s, %s", destinations[0].MinBalance, destinations[1].MinBalance)
+	}
+
+	cfg.Destinations[1].Name = "polygon"
+	cfg.Destinations[1].ChainId = ""
+	err = cfg.Validate()
+	var validationErr config.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected ValidationError, got %v", err)
+	}
+	keys := make(map[string]bool)
+	for _, fieldErr := range validationErr {
+		keys[fieldErr.Key] = true
+	}
+	for _, key := range []string{"Destinations[polygon].Name", "Destinations[polygon].ChainId"} {
+		if !keys[key] {
+			t.Errorf("missing error for %s in %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,323 @@
+// Copyright 2021 stafiprotocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rmatic-relay/pkg/utils"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+)
+
+// sane ranges of the gas settings
+var (
+	MinGasLimit    = decimal.NewFromInt(21000)
+	MaxGasLimit    = decimal.NewFromInt(30e6)
+	MinMaxGasPrice = decimal.NewFromInt(1e9)  // 1gwei
+	MaxMaxGasPrice = decimal.NewFromInt(1e13) // 10000gwei
+
+	MinReplaceTxBlocks = decimal.NewFromInt(1)
+	MaxReplaceTxBlocks = decimal.NewFromInt(1000)
+
+	MaxConfirmBlocks = decimal.NewFromInt(10000)
+
+	MinReadyTickIntervals = decimal.NewFromInt(1)
+	MaxReadyTickIntervals = decimal.NewFromInt(10000)
+	MaxMinBalance         = decimal.New(1, 24) // 1e6 ether
+
+	MaxMaxRateChange = decimal.NewFromInt(1)
+
+	MaxChainId = decimal.NewFromInt(1<<53 - 1) // EIP-2294
+)
+
+// FieldError is a problem found on one config value.
+type FieldError struct {
+	Key string
+	Msg string
+}
+
+func (e FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+// ValidationError holds every problem found by Config.Validate.
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, fieldErr := range e {
+		msgs = append(msgs, fieldErr.Error())
+	}
+	return fmt.Sprintf("invalid config:\n  %s", strings.Join(msgs, "\n  "))
+}
+
+// Validate checks the config of the tasks in Tasks and reports every problem at once.
+func (cfg *Config) Validate() error {
+	var errs ValidationError
+	add := func(key, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Key: key, Msg: fmt.Sprintf(format, args...)})
+	}
+
+	taskTypes, err := utils.ParseTaskTypes(cfg.Tasks)
+	if err != nil {
+		add("Tasks", "%s", err)
+	}
+	needPolygon := false
+	for _, taskType := range taskTypes {
+		if taskType == utils.TaskTypeSyncRate {
+			needPolygon = true
+		}
+	}
+
+	// the key is either in the keystore or behind the external signer
+	usesSigner := len(cfg.SignerEndpoint) != 0
+	if msg := checkAddress(cfg.Account); len(msg) != 0 {
+		add("Account", "%s", msg)
+	} else if len(cfg.KeystorePath) != 0 && !usesSigner {
+		keyFile := filepath.Join(cfg.KeystorePath, cfg.Account+".key")
+		if _, err := os.Stat(keyFile); err != nil {
+			add("Account", "keystore file %s not found", keyFile)
+		}
+	}
+	if usesSigner {
+		if msg := checkEndpoint(cfg.SignerEndpoint); len(msg) != 0 {
+			add("SignerEndpoint", "%s", msg)
+		}
+	} else if len(cfg.KeystorePath) == 0 {
+		add("KeystorePath", "empty")
+	}
+
+	if msg := checkAddress(cfg.StakeMangerAddress); len(msg) != 0 {
+		add("StakeMangerAddress", "%s", msg)
+	}
+	if msg := checkEndpoints(cfg.EthRpcEndpoint); len(msg) != 0 {
+		add("EthRpcEndpoint", "%s", msg)
+	}
+	if needPolygon && len(cfg.Destinations) == 0 {
+		if msg := checkAddress(cfg.PolygonStakePortalRateAddress); len(msg) != 0 {
+			add("PolygonStakePortalRateAddress", "%s", msg)
+		}
+		if msg := checkEndpoints(cfg.PolygonRpcEndpoint); len(msg) != 0 {
+			add("PolygonRpcEndpoint", "%s", msg)
+		}
+	}
+	if needPolygon && len(cfg.Destinations) != 0 {
+		if len(cfg.PolygonRpcEndpoint) != 0 || len(cfg.PolygonStakePortalRateAddress) != 0 {
+			add("Destinations", "can not be used with PolygonRpcEndpoint or PolygonStakePortalRateAddress")
+		}
+		names := make(map[string]bool)
+		for i, destination := range cfg.Destinations {
+			key := fmt.Sprintf("Destinations[%d]", i)
+			if len(destination.Name) != 0 {
+				key = fmt.Sprintf("Destinations[%s]", destination.Name)
+			}
+			for _, fieldErr := range destination.validate() {
+				add(key+"."+fieldErr.Key, "%s", fieldErr.Msg)
+			}
+			if names[destination.Name] {
+				add(key+".Name", "duplicate name")
+			}
+			names[destination.Name] = true
+		}
+	}
+
+	if msg := checkRange(cfg.GasLimit, MinGasLimit, MaxGasLimit); len(msg) != 0 {
+		add("GasLimit", "%s", msg)
+	}
+	if msg := checkRange(cfg.MaxGasPrice, MinMaxGasPrice, MaxMaxGasPrice); len(msg) != 0 {
+		add("MaxGasPrice", "%s", msg)
+	}
+
+	if msg := checkRange(cfg.ReplaceTxBlocks, MinReplaceTxBlocks, MaxReplaceTxBlocks); len(msg) != 0 {
+		add("ReplaceTxBlocks", "%s", msg)
+	}
+	if msg := checkGasPriceMode(cfg.EthGasPriceMode); len(msg) != 0 {
+		add("EthGasPriceMode", "%s", msg)
+	}
+	if msg := checkConfirmBlocks(cfg.EthConfirmBlocks); len(msg) != 0 {
+		add("EthConfirmBlocks", "%s", msg)
+	}
+	if needPolygon {
+		if msg := checkConfirmBlocks(cfg.PolygonConfirmBlocks); len(msg) != 0 {
+			add("PolygonConfirmBlocks", "%s", msg)
+		}
+	}
+	// the destinations fall back to the polygon gas price mode and min balance
+	if needPolygon {
+		if msg := checkGasPriceMode(cfg.PolygonGasPriceMode); len(msg) != 0 {
+			add("PolygonGasPriceMode", "%s", msg)
+		}
+	}
+
+	if len(cfg.MetricsListenAddr) != 0 {
+		if _, _, err := net.SplitHostPort(cfg.MetricsListenAddr); err != nil {
+			add("MetricsListenAddr", "%s", err)
+		}
+	}
+
+	if msg := checkRange(cfg.EthMinBalance, decimal.Zero, MaxMinBalance); len(msg) != 0 {
+		add("EthMinBalance", "%s", msg)
+	}
+	if needPolygon {
+		if msg := checkRange(cfg.PolygonMinBalance, decimal.Zero, MaxMinBalance); len(msg) != 0 {
+			add("PolygonMinBalance", "%s", msg)
+		}
+	}
+	if needPolygon {
+		if msg := checkDecimalRange(cfg.MaxRateChange, decimal.Zero, MaxMaxRateChange); len(msg) != 0 {
+			add("MaxRateChange", "%s", msg)
+		}
+	}
+	if msg := checkRange(cfg.ReadyTickIntervals, MinReadyTickIntervals, MaxReadyTickIntervals); len(msg) != 0 {
+		add("ReadyTickIntervals", "%s", msg)
+	}
+
+	if _, err := logrus.ParseLevel(cfg.LogLevel); err != nil {
+		add("LogLevel", "%s", err)
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks one destination, the keys of the returned errors are its field names.
+func (d *Destination) validate() []FieldError {
+	var errs []FieldError
+	add := func(key, msg string) {
+		errs = append(errs, FieldError{Key: key, Msg: msg})
+	}
+
+	if len(d.Name) == 0 {
+		add("Name", "empty")
+	}
+	if msg := checkAddress(d.StakePortalRateAddress); len(msg) != 0 {
+		add("StakePortalRateAddress", msg)
+	}
+	if msg := checkEndpoints(d.RpcEndpoint); len(msg) != 0 {
+		add("RpcEndpoint", msg)
+	}
+	if msg := checkRange(d.ChainId, decimal.NewFromInt(1), MaxChainId); len(msg) != 0 {
+		add("ChainId", msg)
+	}
+	if len(d.GasLimit) != 0 {
+		if msg := checkRange(d.GasLimit, MinGasLimit, MaxGasLimit); len(msg) != 0 {
+			add("GasLimit", msg)
+		}
+	}
+	if len(d.MaxGasPrice) != 0 {
+		if msg := checkRange(d.MaxGasPrice, MinMaxGasPrice, MaxMaxGasPrice); len(msg) != 0 {
+			add("MaxGasPrice", msg)
+		}
+	}
+	if msg := checkGasPriceMode(d.GasPriceMode); len(msg) != 0 {
+		add("GasPriceMode", msg)
+	}
+	if len(d.ConfirmBlocks) != 0 {
+		if msg := checkConfirmBlocks(d.ConfirmBlocks); len(msg) != 0 {
+			add("ConfirmBlocks", msg)
+		}
+	}
+	if len(d.MinBalance) != 0 {
+		if msg := checkRange(d.MinBalance, decimal.Zero, MaxMinBalance); len(msg) != 0 {
+			add("MinBalance", msg)
+		}
+	}
+	return errs
+}
+
+func checkAddress(addr string) string {
+	if len(addr) == 0 {
+		return "empty address"
+	}
+	if !common.IsHexAddress(addr) {
+		return fmt.Sprintf("not hex address: %s", addr)
+	}
+	if common.HexToAddress(addr) == (common.Address{}) {
+		return "zero address"
+	}
+	return ""
+}
+
+func checkEndpoints(endpoints string) string {
+	list := SplitEndpoints(endpoints)
+	if len(list) == 0 {
+		return "empty endpoint"
+	}
+	seen := make(map[string]bool)
+	for _, endpoint := range list {
+		if seen[endpoint] {
+			return fmt.Sprintf("duplicate endpoint %s", endpoint)
+		}
+		seen[endpoint] = true
+		if msg := checkEndpoint(endpoint); len(msg) != 0 {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkEndpoint(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Sprintf("unparseable endpoint: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+	default:
+		return fmt.Sprintf("unsupported endpoint scheme: %q", u.Scheme)
+	}
+	if len(u.Host) == 0 {
+		return "endpoint has no host"
+	}
+	return ""
+}
+
+func checkRange(value string, min, max decimal.Decimal) string {
+	valueDeci, err := decimal.NewFromString(value)
+	if err != nil {
+		return fmt.Sprintf("not a number: %q", value)
+	}
+	if !valueDeci.IsInteger() {
+		return fmt.Sprintf("not an integer: %s", value)
+	}
+	return checkDecimalRange(value, min, max)
+}
+
+// checkDecimalRange is checkRange without the integer requirement.
+func checkDecimalRange(value string, min, max decimal.Decimal) string {
+	valueDeci, err := decimal.NewFromString(value)
+	if err != nil {
+		return fmt.Sprintf("not a number: %q", value)
+	}
+	if valueDeci.LessThan(min) || valueDeci.GreaterThan(max) {
+		return fmt.Sprintf("%s out of range [%s, %s]", value, min, max)
+	}
+	return ""
+}
+
+// checkConfirmBlocks accepts a block count or "finalized".
+func checkConfirmBlocks(value string) string {
+	if value == ConfirmFinalized {
+		return ""
+	}
+	return checkRange(value, decimal.Zero, MaxConfirmBlocks)
+}
+
+func checkGasPriceMode(mode string) string {
+	switch mode {
+	case "", GasPriceModeDynamic, GasPriceModeLegacy:
+		return ""
+	default:
+		return fmt.Sprintf("unknown gas price mode %q, should be %s or %s", mode, GasPriceModeDynamic, GasPriceModeLegacy)
+	}
+}
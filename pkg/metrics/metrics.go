@@ -0,0 +1,125 @@
+// Copyright 2021 stafiprotocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package metrics
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "rmatic_relay"
+
+var (
+	CurrentEra = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "current_era",
+		Help:      "CurrentEra of the StakeManager contract.",
+	})
+	LatestEra = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "latest_era",
+		Help:      "LatestEra of the StakeManager contract.",
+	})
+	Rate = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "rate",
+		Help:      "rMATIC rate returned by GetRate, divided by 1e18.",
+	}, []string{"chain"})
+	TaskTicks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_ticks_total",
+		Help:      "Ticks of each task by result.",
+	}, []string{"task", "result"})
+	Txs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "txs_total",
+		Help:      "Transactions by event: send, confirm, revert or reorg.",
+	}, []string{"chain", "event"})
+	TxGasUsed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tx_gas_used_total",
+		Help:      "Gas used by mined transactions.",
+	}, []string{"chain"})
+	TxFee = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tx_fee_total",
+		Help:      "Fee paid by mined transactions, in ether.",
+	}, []string{"chain"})
+	SignerBalance = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "signer_balance",
+		Help:      "Balance of the signer account, in ether.",
+	}, []string{"chain"})
+	RateVoteRefused = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_vote_refused_total",
+		Help:      "Rate votes refused by the rate change limit check, by destination and limit.",
+	}, []string{"destination", "limit"})
+	DestinationSyncHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "destination_sync_healthy",
+		Help:      "1 when the last rate sync of a destination succeeded or was refused by the rate change limit, 0 when it failed.",
+	}, []string{"destination"})
+	RpcDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Latency of rpc calls by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"chain", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(CurrentEra, LatestEra, Rate, TaskTicks, Txs, TxGasUsed, TxFee, SignerBalance, RateVoteRefused, DestinationSyncHealthy, RpcDuration)
+}
+
+// tx events
+const (
+	TxEventSend    = "send"
+	TxEventConfirm = "confirm"
+	TxEventRevert  = "revert"
+	TxEventReorg   = "reorg"
+)
+
+var chainNames = map[uint64]string{
+	1:        "ethereum",
+	5:        "goerli",
+	11155111: "sepolia",
+	137:      "polygon",
+	80001:    "mumbai",
+	80002:    "amoy",
+}
+
+// ChainName returns the chain label of chainId.
+func ChainName(chainId *big.Int) string {
+	if chainId == nil {
+		return "unknown"
+	}
+	if name, exist := chainNames[chainId.Uint64()]; exist {
+		return name
+	}
+	return chainId.String()
+}
+
+// ObserveRpc records the latency of an rpc call started at start.
+func ObserveRpc(chain, method string, start time.Time) {
+	RpcDuration.WithLabelValues(chain, method).Observe(time.Since(start).Seconds())
+}
+
+// WeiToEther converts wei to a float ether value, for gauges only.
+func WeiToEther(wei *big.Int) float64 {
+	return BigToFloat(wei, 18)
+}
+
+// BigToFloat converts value divided by 10^decimals to float64.
+func BigToFloat(value *big.Int, decimals int) float64 {
+	if value == nil {
+		return 0
+	}
+	f := new(big.Float).SetInt(value)
+	f.Quo(f, new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil)))
+	ret, _ := f.Float64()
+	return ret
+}
@@ -0,0 +1,89 @@
+// Copyright 2021 stafiprotocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package store
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// key prefixes of the recorded txs, a tx and its replacements share one key
+const (
+	NewEraKeyPrefix   = "newEra/"
+	VoteRateKeyPrefix = "voteRate/"
+)
+
+// NewEraKey is the key of the newEra txs of era.
+func NewEraKey(era uint64) string {
+	return fmt.Sprintf("%s%d", NewEraKeyPrefix, era)
+}
+
+// ParseNewEraKey returns the era of a NewEraKey.
+func ParseNewEraKey(key string) (uint64, error) {
+	if !strings.HasPrefix(key, NewEraKeyPrefix) {
+		return 0, fmt.Errorf("not a newEra key: %s", key)
+	}
+	era, err := strconv.ParseUint(strings.TrimPrefix(key, NewEraKeyPrefix), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("era of key %s: %w", key, err)
+	}
+	return era, nil
+}
+
+// VoteKey identifies the voteRate txs of a proposal on a destination.
+type VoteKey struct {
+	Destination string
+	Era         uint32
+	Rate        *big.Int
+	ProposalId  common.Hash
+}
+
+// VoteKeyPrefix is the prefix of the VoteKeys of destination.
+func VoteKeyPrefix(destination string) string {
+	return VoteRateKeyPrefix + destination + "/"
+}
+
+func (k *VoteKey) String() string {
+	return fmt.Sprintf("%s%d/%s/%s", VoteKeyPrefix(k.Destination), k.Era, k.Rate.String(), k.ProposalId.String())
+}
+
+// ParseVoteKey parses the String of a VoteKey. The destination may hold a
+// slash, the other fields are split from the right.
+func ParseVoteKey(key string) (*VoteKey, error) {
+	if !strings.HasPrefix(key, VoteRateKeyPrefix) {
+		return nil, fmt.Errorf("not a voteRate key: %s", key)
+	}
+	parts := strings.Split(strings.TrimPrefix(key, VoteRateKeyPrefix), "/")
+	n := len(parts)
+	if n < 4 {
+		return nil, fmt.Errorf("not a voteRate key: %s", key)
+	}
+	destination := strings.Join(parts[:n-3], "/")
+	if len(destination) == 0 {
+		return nil, fmt.Errorf("destination of key %s empty", key)
+	}
+	era, err := strconv.ParseUint(parts[n-3], 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("era of key %s: %w", key, err)
+	}
+	rate, ok := new(big.Int).SetString(parts[n-2], 10)
+	if !ok || rate.Sign() < 0 {
+		return nil, fmt.Errorf("rate of key %s not a natural number", key)
+	}
+	proposalId, err := hexutil.Decode(parts[n-1])
+	if err != nil || len(proposalId) != common.HashLength {
+		return nil, fmt.Errorf("proposal id of key %s not a hash", key)
+	}
+	return &VoteKey{
+		Destination: destination,
+		Era:         uint32(era),
+		Rate:        rate,
+		ProposalId:  common.BytesToHash(proposalId),
+	}, nil
+}
@@ -0,0 +1,71 @@
+package store_test
+
+import (
+	"math/big"
+	"rmatic-relay/pkg/store"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestParseVoteKey(t *testing.T) {
+	proposalId := common.HexToHash("0x5c1f")
+	cases := []struct {
+		name   string
+		key    string
+		expect *store.VoteKey
+	}{
+		{
+			name:   "round trip",
+			key:    (&store.VoteKey{Destination: "polygon", Era: 7, Rate: big.NewInt(1e18), ProposalId: proposalId}).String(),
+			expect: &store.VoteKey{Destination: "polygon", Era: 7, Rate: big.NewInt(1e18), ProposalId: proposalId},
+		},
+		{
+			name:   "destination with a slash",
+			key:    (&store.VoteKey{Destination: "polygon/amoy", Era: 7, Rate: big.NewInt(1), ProposalId: proposalId}).String(),
+			expect: &store.VoteKey{Destination: "polygon/amoy", Era: 7, Rate: big.NewInt(1), ProposalId: proposalId},
+		},
+		{name: "newEra key", key: store.NewEraKey(7)},
+		{name: "no prefix", key: "polygon/7/1/" + proposalId.String()},
+		{name: "missing field", key: "voteRate/polygon/7/" + proposalId.String()},
+		{name: "empty destination", key: "voteRate//7/1/" + proposalId.String()},
+		{name: "era not integer", key: "voteRate/polygon/x/1/" + proposalId.String()},
+		{name: "era over uint32", key: "voteRate/polygon/4294967296/1/" + proposalId.String()},
+		{name: "rate not integer", key: "voteRate/polygon/7/1.5/" + proposalId.String()},
+		{name: "negative rate", key: "voteRate/polygon/7/-1/" + proposalId.String()},
+		{name: "short proposal id", key: "voteRate/polygon/7/1/0x5c1f"},
+		{name: "proposal id not hex", key: "voteRate/polygon/7/1/" + proposalId.String()[2:]},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, err := store.ParseVoteKey(c.key)
+			if c.expect == nil {
+				if err == nil {
+					t.Fatalf("expected error for %s, got %+v", c.key, key)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Destination != c.expect.Destination || key.Era != c.expect.Era || key.Rate.Cmp(c.expect.Rate) != 0 || key.ProposalId != c.expect.ProposalId {
+				t.Fatalf("expected %+v, got %+v", c.expect, key)
+			}
+			if key.String() != c.key {
+				t.Fatalf("expected String %s, got %s", c.key, key.String())
+			}
+		})
+	}
+}
+
+func TestParseNewEraKey(t *testing.T) {
+	era, err := store.ParseNewEraKey(store.NewEraKey(42))
+	if err != nil || era != 42 {
+		t.Fatalf("expected era 42, got %d, %v", era, err)
+	}
+	for _, key := range []string{"newEra/", "newEra/x", "voteRate/42"} {
+		if _, err := store.ParseNewEraKey(key); err == nil {
+			t.Errorf("expected error for %s", key)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Copyright 2021 stafiprotocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// tx statuses
+const (
+	TxStatusPending  = "pending"
+	TxStatusSuccess  = "success"
+	TxStatusReverted = "reverted"
+	TxStatusReplaced = "replaced"
+	TxStatusDropped  = "dropped"
+)
+
+// MaxRecords bounds the finished records kept of each kind, the oldest are pruned.
+var MaxRecords = 1000
+
+// EraRecord is an era whose newEra was executed by this relayer.
+type EraRecord struct {
+	Era    uint64    `json:"era"`
+	TxHash string    `json:"txHash"`
+	Time   time.Time `json:"time"`
+}
+
+// VoteRecord is a rate voted by this relayer.
+type VoteRecord struct {
+	Destination string    `json:"destination"`
+	Era         uint64    `json:"era"`
+	Rate        string    `json:"rate"`
+	ProposalId  string    `json:"proposalId"`
+	TxHash      string    `json:"txHash"`
+	Time        time.Time `json:"time"`
+}
+
+// TxRecord is a tx sent by this relayer. Key groups the tx with its
+// replacements, which share one nonce.
+type TxRecord struct {
+	Key       string    `json:"key"`
+	ChainId   string    `json:"chainId"`
+	Hash      string    `json:"hash"`
+	Nonce     uint64    `json:"nonce"`
+	GasPrice  string    `json:"gasPrice,omitempty"`
+	GasFeeCap string    `json:"gasFeeCap,omitempty"`
+	GasTipCap string    `json:"gasTipCap,omitempty"`
+	Raw       string    `json:"raw"`
+	Status    string    `json:"status"`
+	SentAt    time.Time `json:"sentAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type state struct {
+	Eras  []EraRecord  `json:"eras"`
+	Votes []VoteRecord `json:"votes"`
+	Txs   []*TxRecord  `json:"txs"`
+}
+
+// Store keeps the activity of the relayer in a json file, rewritten atomically
+// on every change, so that in-flight txs survive a restart. The methods of a
+// nil Store do nothing.
+type Store struct {
+	path  string
+	lock  sync.Mutex
+	state state
+}
+
+// Open loads the store at path, an absent file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path}
+	bts, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(bts, &s.state); err != nil {
+			return nil, fmt.Errorf("decode store %s failed: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// save writes the state to a temp file and renames it over the store file.
+func (s *Store) save() error {
+	bts, err := json.MarshalIndent(&s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, bts, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// RecordEra records an era executed by newEra.
+func (s *Store) RecordEra(era uint64, txHash common.Hash) error {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.state.Eras = append(s.state.Eras, EraRecord{Era: era, TxHash: txHash.String(), Time: time.Now()})
+	if len(s.state.Eras) > MaxRecords {
+		s.state.Eras = s.state.Eras[len(s.state.Eras)-MaxRecords:]
+	}
+	return s.save()
+}
+
+// RecordVote records a rate voted on a destination.
+func (s *Store) RecordVote(vote VoteRecord) error {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	vote.Time = time.Now()
+	s.state.Votes = append(s.state.Votes, vote)
+	if len(s.state.Votes) > MaxRecords {
+		s.state.Votes = s.state.Votes[len(s.state.Votes)-MaxRecords:]
+	}
+	return s.save()
+}
+
+// LastVotedEra returns the highest era voted on destination, false if none is
+// recorded.
+func (s *Store) LastVotedEra(destination string) (uint64, bool) {
+	if s == nil {
+		return 0, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var era uint64
+	found := false
+	for _, vote := range s.state.Votes {
+		if vote.Destination == destination && (!found || vote.Era > era) {
+			era = vote.Era
+			found = true
+		}
+	}
+	return era, found
+}
+
+// RecordTx records tx of key as pending.
+func (s *Store) RecordTx(key string, chainId *big.Int, tx *types.Transaction) error {
+	if s == nil {
+		return nil
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	record := &TxRecord{
+		Key:       key,
+		ChainId:   chainId.String(),
+		Hash:      tx.Hash().String(),
+		Nonce:     tx.Nonce(),
+		Raw:       hexutil.Encode(raw),
+		Status:    TxStatusPending,
+		SentAt:    time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if tx.Type() == types.LegacyTxType {
+		record.GasPrice = tx.GasPrice().String()
+	} else {
+		record.GasFeeCap = tx.GasFeeCap().String()
+		record.GasTipCap = tx.GasTipCap().String()
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state.Txs = append(s.state.Txs, record)
+	s.pruneTxs()
+	return s.save()
+}
+
+// SetTxStatus updates the status of the tx of hash.
+func (s *Store) SetTxStatus(hash common.Hash, status string) error {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, record := range s.state.Txs {
+		if record.Hash == hash.String() {
+			record.Status = status
+			record.UpdatedAt = time.Now()
+			return s.save()
+		}
+	}
+	return fmt.Errorf("tx %s not in store", hash.String())
+}
+
+// InFlightTxs returns the txs of key on chainId still waiting to be mined, in
+// send order. These are the pending one and the replaced ones of its nonce,
+// any of which may still be mined.
+func (s *Store) InFlightTxs(key string, chainId *big.Int) ([]*types.Transaction, error) {
+	if s == nil {
+		return nil, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	records := make([]*TxRecord, 0)
+	pendingNonces := make(map[uint64]bool)
+	for _, record := range s.state.Txs {
+		if record.Key != key || record.ChainId != chainId.String() {
+			continue
+		}
+		records = append(records, record)
+		if record.Status == TxStatusPending {
+			pendingNonces[record.Nonce] = true
+		}
+	}
+
+	txs := make([]*types.Transaction, 0)
+	for _, record := range records {
+		if !pendingNonces[record.Nonce] || (record.Status != TxStatusPending && record.Status != TxStatusReplaced) {
+			continue
+		}
+		raw, err := hexutil.Decode(record.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("decode tx %s failed: %w", record.Hash, err)
+		}
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(raw); err != nil {
+			return nil, fmt.Errorf("decode tx %s failed: %w", record.Hash, err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// InFlightKeys returns the keys starting with prefix that have a pending tx on
+// chainId, in send order.
+func (s *Store) InFlightKeys(prefix string, chainId *big.Int) []string {
+	if s == nil {
+		return nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, record := range s.state.Txs {
+		if record.Status != TxStatusPending || record.ChainId != chainId.String() || !strings.HasPrefix(record.Key, prefix) || seen[record.Key] {
+			continue
+		}
+		seen[record.Key] = true
+		keys = append(keys, record.Key)
+	}
+	return keys
+}
+
+// pruneTxs drops the oldest finished txs above MaxRecords, pending ones are kept.
+func (s *Store) pruneTxs() {
+	finished := 0
+	for _, record := range s.state.Txs {
+		if record.Status != TxStatusPending {
+			finished++
+		}
+	}
+	if finished <= MaxRecords {
+		return
+	}
+	drop := finished - MaxRecords
+	kept := make([]*TxRecord, 0, len(s.state.Txs)-drop)
+	for _, record := range s.state.Txs {
+		if drop > 0 && record.Status != TxStatusPending {
+			drop--
+			continue
+		}
+		kept = append(kept, record)
+	}
+	s.state.Txs = kept
+}
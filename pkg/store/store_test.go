@@ -0,0 +1,100 @@
+package store_test
+
+import (
+	"math/big"
+	"path/filepath"
+	"rmatic-relay/pkg/store"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestStoreInFlightTxs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	chainId := big.NewInt(137)
+	signer := types.LatestSignerForChainID(chainId)
+	sign := func(tip int64) *types.Transaction {
+		tx, err := types.SignNewTx(key, signer, &types.DynamicFeeTx{
+			ChainID:   chainId,
+			Nonce:     7,
+			GasTipCap: big.NewInt(tip),
+			GasFeeCap: big.NewInt(100e9),
+			Gas:       100000,
+			To:        &common.Address{1},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+	first, replacement := sign(30e9), sign(35e9)
+	voteKey := (&store.VoteKey{Destination: "polygon", Era: 12, Rate: big.NewInt(1e18), ProposalId: common.Hash{1}}).String()
+
+	s, err := store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tx := range []*types.Transaction{first, replacement} {
+		if err := s.RecordTx(voteKey, chainId, tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetTxStatus(first.Hash(), store.TxStatusReplaced); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopen as after a restart
+	s, err = store.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, err := s.InFlightTxs(voteKey, chainId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].Hash() != first.Hash() || txs[1].Hash() != replacement.Hash() {
+		t.Fatalf("expected both txs of the nonce in send order, got %d", len(txs))
+	}
+	if keys := s.InFlightKeys(store.VoteKeyPrefix("polygon"), chainId); len(keys) != 1 || keys[0] != voteKey {
+		t.Errorf("expected in-flight key %s, got %v", voteKey, keys)
+	}
+	if txs, _ := s.InFlightTxs(voteKey, big.NewInt(1)); len(txs) != 0 {
+		t.Errorf("expected no in-flight txs on another chain, got %d", len(txs))
+	}
+
+	if err := s.SetTxStatus(replacement.Hash(), store.TxStatusSuccess); err != nil {
+		t.Fatal(err)
+	}
+	if txs, _ := s.InFlightTxs(voteKey, chainId); len(txs) != 0 {
+		t.Errorf("expected no in-flight txs after success, got %d", len(txs))
+	}
+}
+
+func TestStoreLastVotedEra(t *testing.T) {
+	s, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.LastVotedEra("polygon"); ok {
+		t.Fatal("expected no voted era in an empty store")
+	}
+	for _, vote := range []store.VoteRecord{
+		{Destination: "polygon", Era: 12},
+		{Destination: "polygon", Era: 14},
+		{Destination: "polygon", Era: 13},
+		{Destination: "bsc", Era: 20},
+	} {
+		if err := s.RecordVote(vote); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if era, ok := s.LastVotedEra("polygon"); !ok || era != 14 {
+		t.Fatalf("expected era 14 voted on polygon, got %d", era)
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// PasswordSource names where a keystore password is read from without a
+// prompt, at most one of File, Env and Fd is set. Fd is negative when unset.
+type PasswordSource struct {
+	// File is a password file, refused when readable by others
+	File string
+	// Env is the name of the environment variable holding the password
+	Env string
+	// Fd is an open file descriptor to read the password from, such as a pipe
+	Fd int
+}
+
+// IsSet reports whether a non-interactive source is given.
+func (s *PasswordSource) IsSet() bool {
+	return len(s.File) != 0 || len(s.Env) != 0 || s.Fd >= 0
+}
+
+// ReadSecretFile reads a file holding a secret, such as a password or a
+// private key, refusing it when readable by others.
+func ReadSecretFile(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		return nil, fmt.Errorf("secret file %s is world-readable (%s), restrict it with chmod 600", path, info.Mode().Perm())
+	}
+	return os.ReadFile(path)
+}
+
+// Read returns the password of the source, a trailing newline is dropped.
+func (s *PasswordSource) Read() ([]byte, error) {
+	set := 0
+	for _, isSet := range []bool{len(s.File) != 0, len(s.Env) != 0, s.Fd >= 0} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, fmt.Errorf("exactly one of password file, env and fd is required")
+	}
+
+	var password string
+	switch {
+	case len(s.File) != 0:
+		bts, err := ReadSecretFile(s.File)
+		if err != nil {
+			return nil, err
+		}
+		password = string(bts)
+	case len(s.Env) != 0:
+		value, exist := os.LookupEnv(s.Env)
+		if !exist {
+			return nil, fmt.Errorf("password env %s not set", s.Env)
+		}
+		password = value
+	default:
+		file := os.NewFile(uintptr(s.Fd), fmt.Sprintf("fd%d", s.Fd))
+		if file == nil {
+			return nil, fmt.Errorf("invalid password fd %d", s.Fd)
+		}
+		defer file.Close()
+		bts, err := io.ReadAll(file)
+		if err != nil {
+			return nil, fmt.Errorf("read password fd %d failed: %w", s.Fd, err)
+		}
+		password = string(bts)
+	}
+
+	password = strings.TrimSuffix(strings.TrimSuffix(password, "\n"), "\r")
+	if len(password) == 0 {
+		return nil, fmt.Errorf("password is empty")
+	}
+	return []byte(password), nil
+}
@@ -0,0 +1,52 @@
+package utils_test
+
+import (
+	"os"
+	"path/filepath"
+	"rmatic-relay/pkg/utils"
+	"testing"
+)
+
+func TestPasswordSource(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(file, []byte("secret\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&utils.PasswordSource{File: file, Fd: -1}).Read(); err == nil {
+		t.Fatal("expected a world-readable password file to be refused")
+	}
+	if err := os.Chmod(file, 0600); err != nil {
+		t.Fatal(err)
+	}
+	password, err := (&utils.PasswordSource{File: file, Fd: -1}).Read()
+	if err != nil || string(password) != "secret" {
+		t.Fatalf("password file: %q, %v", password, err)
+	}
+
+	t.Setenv("RMATIC_TEST_PASSWORD", "from env")
+	password, err = (&utils.PasswordSource{Env: "RMATIC_TEST_PASSWORD", Fd: -1}).Read()
+	if err != nil || string(password) != "from env" {
+		t.Fatalf("password env: %q, %v", password, err)
+	}
+	if _, err := (&utils.PasswordSource{Env: "RMATIC_TEST_PASSWORD_UNSET", Fd: -1}).Read(); err == nil {
+		t.Fatal("expected error for an unset env")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("from fd\r\n")
+	w.Close()
+	password, err = (&utils.PasswordSource{Fd: int(r.Fd())}).Read()
+	if err != nil || string(password) != "from fd" {
+		t.Fatalf("password fd: %q, %v", password, err)
+	}
+
+	if _, err := (&utils.PasswordSource{File: file, Env: "RMATIC_TEST_PASSWORD", Fd: -1}).Read(); err == nil {
+		t.Fatal("expected error for two sources")
+	}
+	if (&utils.PasswordSource{Fd: -1}).IsSet() {
+		t.Fatal("expected no source set")
+	}
+}
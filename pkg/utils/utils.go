@@ -7,6 +7,7 @@ import (
 	"io"
 	"math/big"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -31,6 +32,49 @@ var (
 	TaskTypeSyncRate = uint8(2)
 )
 
+var taskTypeNames = map[uint8]string{
+	TaskTypeNewEra:   "new_era",
+	TaskTypeSyncRate: "sync_rate",
+}
+
+// TaskTypeName returns the name used for the task type in flags and logs.
+func TaskTypeName(taskType uint8) string {
+	if name, exist := taskTypeNames[taskType]; exist {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", taskType)
+}
+
+// ParseTaskTypes parses a comma separated task name list such as "new_era,sync_rate".
+func ParseTaskTypes(names string) ([]uint8, error) {
+	taskTypes := make([]uint8, 0)
+	seen := make(map[uint8]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		found := false
+		for taskType, typeName := range taskTypeNames {
+			if typeName != name {
+				continue
+			}
+			found = true
+			if !seen[taskType] {
+				seen[taskType] = true
+				taskTypes = append(taskTypes, taskType)
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown task: %s", name)
+		}
+	}
+	if len(taskTypes) == 0 {
+		return nil, fmt.Errorf("no task specified")
+	}
+	return taskTypes, nil
+}
+
 func GetBcDelegationAddressFromBsc(addrBts []byte) bncCmnTypes.AccAddress {
 	return GetStakeCAoB(addrBts, DelegateCAoBSalt)
 }
@@ -47,3 +47,20 @@ func TestNewReward(t *testing.T) {
 	}
 	t.Log(reward, max)
 }
+
+func TestParseTaskTypes(t *testing.T) {
+	taskTypes, err := utils.ParseTaskTypes("new_era, sync_rate,new_era")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(taskTypes) != 2 || taskTypes[0] != utils.TaskTypeNewEra || taskTypes[1] != utils.TaskTypeSyncRate {
+		t.Fatalf("unexpected task types: %v", taskTypes)
+	}
+
+	if _, err := utils.ParseTaskTypes("new_era,unknown"); err == nil {
+		t.Fatal("expected error for unknown task")
+	}
+	if _, err := utils.ParseTaskTypes(""); err == nil {
+		t.Fatal("expected error for empty task list")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"math/big"
+	"rmatic-relay/pkg/metrics"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Client implements bind.ContractBackend on the active endpoint, so contract
+// bindings built on it follow endpoint switches.
+var (
+	_ bind.ContractBackend       = &Client{}
+	_ bind.PendingContractCaller = &Client{}
+)
+
+func (c *Client) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getCode", time.Now())
+	conn := c.Client()
+	code, err := conn.CodeAt(ctx, contract, blockNumber)
+	c.checkErr(conn, err)
+	return code, err
+}
+
+func (c *Client) CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_call", time.Now())
+	conn := c.Client()
+	res, err := conn.CallContract(ctx, call, blockNumber)
+	c.checkErr(conn, err)
+	return res, err
+}
+
+func (c *Client) PendingCallContract(ctx context.Context, call ethereum.CallMsg) ([]byte, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_call", time.Now())
+	conn := c.Client()
+	res, err := conn.PendingCallContract(ctx, call)
+	c.checkErr(conn, err)
+	return res, err
+}
+
+func (c *Client) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getBlockByNumber", time.Now())
+	conn := c.Client()
+	header, err := conn.HeaderByNumber(ctx, number)
+	c.checkErr(conn, err)
+	return header, err
+}
+
+func (c *Client) PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getCode", time.Now())
+	conn := c.Client()
+	code, err := conn.PendingCodeAt(ctx, account)
+	c.checkErr(conn, err)
+	return code, err
+}
+
+func (c *Client) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getTransactionCount", time.Now())
+	conn := c.Client()
+	nonce, err := conn.NonceAt(ctx, account, blockNumber)
+	c.checkErr(conn, err)
+	return nonce, err
+}
+
+func (c *Client) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getTransactionCount", time.Now())
+	conn := c.Client()
+	nonce, err := conn.PendingNonceAt(ctx, account)
+	c.checkErr(conn, err)
+	return nonce, err
+}
+
+func (c *Client) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_gasPrice", time.Now())
+	conn := c.Client()
+	gasPrice, err := conn.SuggestGasPrice(ctx)
+	c.checkErr(conn, err)
+	return gasPrice, err
+}
+
+func (c *Client) SuggestGasTipCap(ctx context.Context) (*big.Int, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_maxPriorityFeePerGas", time.Now())
+	conn := c.Client()
+	tipCap, err := conn.SuggestGasTipCap(ctx)
+	c.checkErr(conn, err)
+	return tipCap, err
+}
+
+func (c *Client) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_estimateGas", time.Now())
+	conn := c.Client()
+	gas, err := conn.EstimateGas(ctx, call)
+	c.checkErr(conn, err)
+	return gas, err
+}
+
+func (c *Client) SendTransaction(ctx context.Context, tx *types.Transaction) error {
+	defer metrics.ObserveRpc(c.chainName(), "eth_sendRawTransaction", time.Now())
+	conn := c.Client()
+	err := conn.SendTransaction(ctx, tx)
+	c.checkErr(conn, err)
+	if err == nil {
+		metrics.Txs.WithLabelValues(c.chainName(), metrics.TxEventSend).Inc()
+	}
+	if c.nonces != nil {
+		if from, senderErr := types.Sender(types.LatestSignerForChainID(c.chainId), tx); senderErr == nil && from == c.opts.From {
+			c.nonces.Sent(tx.Nonce(), tx.Hash(), err)
+		}
+	}
+	return err
+}
+
+func (c *Client) BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getBalance", time.Now())
+	conn := c.Client()
+	balance, err := conn.BalanceAt(ctx, account, blockNumber)
+	c.checkErr(conn, err)
+	return balance, err
+}
+
+func (c *Client) FilterLogs(ctx context.Context, query ethereum.FilterQuery) ([]types.Log, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_getLogs", time.Now())
+	conn := c.Client()
+	logs, err := conn.FilterLogs(ctx, query)
+	c.checkErr(conn, err)
+	return logs, err
+}
+
+func (c *Client) SubscribeFilterLogs(ctx context.Context, query ethereum.FilterQuery, ch chan<- types.Log) (ethereum.Subscription, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_subscribe", time.Now())
+	conn := c.Client()
+	sub, err := conn.SubscribeFilterLogs(ctx, query, ch)
+	c.checkErr(conn, err)
+	return sub, err
+}
@@ -5,17 +5,18 @@ package shared
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"rmatic-relay/pkg/metrics"
 	"sync"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
-	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+	"github.com/sirupsen/logrus"
 )
 
 var (
@@ -23,6 +24,10 @@ var (
 	DefaultGasLimit      = big.NewInt(100e4)
 	DefaultExtraGasLimit = big.NewInt(15e4)
 
+	dialRetryLimit = 5
+
+	ErrZeroGasLimit = errors.New("gas limit is zero, pass DefaultGasLimit for the default")
+
 	DefaultGasPrice   = big.NewInt(50e9) //50gwei
 	lowExtraGasPrice  = big.NewInt(2e9)  // 5gwei
 	highExtraGasPrice = big.NewInt(5e9)  //5gwei
@@ -30,23 +35,42 @@ var (
 )
 
 type Client struct {
-	endpoint    string
-	kp          *secp256k1.Keypair
+	endpoints   []*endpoint
+	active      int
+	connLock    sync.RWMutex
+	chainId     *big.Int
+	signer      Signer
 	gasLimit    *big.Int
 	maxGasPrice *big.Int
-	conn        *ethclient.Client
-	opts        *bind.TransactOpts
-	nonce       uint64
-	optsLock    sync.Mutex
+	// legacyGasPrice sends legacy transactions instead of dynamic fee ones
+	legacyGasPrice bool
+	// reads and receipts are confirmed this many blocks below the head, or
+	// at the finalized block when confirmFinalized
+	confirmBlocks    uint64
+	confirmFinalized bool
+	opts             *bind.TransactOpts
+	// nonces is shared with the other clients of the account on this chain
+	nonces   *NonceManager
+	optsLock sync.Mutex
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-// NewClient returns an uninitialized connection, must call Client.Connect() before using.
-func NewClient(endpoint string, kp *secp256k1.Keypair, gasLimit, maxGasPrice *big.Int) (*Client, error) {
+// NewClient dials the ordered endpoints and returns a client that fails over
+// between them. All reachable endpoints must report the same chain ID. A nil
+// signer makes a read only client.
+func NewClient(endpoints []string, signer Signer, gasLimit, maxGasPrice *big.Int) (*Client, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no endpoint")
+	}
 	client := &Client{
-		endpoint:    endpoint,
-		kp:          kp,
+		signer:      signer,
 		gasLimit:    gasLimit,
 		maxGasPrice: maxGasPrice,
+		stop:        make(chan struct{}),
+	}
+	for _, e := range endpoints {
+		client.endpoints = append(client.endpoints, &endpoint{url: e})
 	}
 
 	if client.gasLimit == nil || client.gasLimit.Uint64() == 0 {
@@ -62,81 +86,113 @@ func NewClient(endpoint string, kp *secp256k1.Keypair, gasLimit, maxGasPrice *bi
 		return nil, err
 	}
 
+	go client.healthCheckLoop()
+
 	return client, nil
 }
 
-// Connect starts the ethereum WS connection
+// Connect dials every endpoint and checks their chain ID
 func (c *Client) connect() error {
-	client, err := ethclient.Dial(c.endpoint)
-	if err != nil {
-		return err
-	}
-
-	c.conn = client
-
-	var chainId *big.Int
-	retry := 0
-	for {
-		if retry > 50 {
-			return fmt.Errorf("get chainId err: %s", err)
+	for _, e := range c.endpoints {
+		conn, err := ethclient.Dial(e.url)
+		if err != nil {
+			logrus.Warnf("dial endpoint %s failed: %s", e.url, err)
+			continue
+		}
+		e.conn = conn
+
+		var chainId *big.Int
+		retry := 0
+		for {
+			if retry > dialRetryLimit {
+				break
+			}
+			chainId, err = conn.ChainID(context.Background())
+			if err != nil {
+				retry++
+				time.Sleep(time.Second * 3)
+				continue
+			}
+			break
 		}
-		chainId, err = c.conn.ChainID(context.Background())
 		if err != nil {
-			retry++
-			time.Sleep(time.Second * 3)
+			logrus.Warnf("get chainId of endpoint %s failed: %s", e.url, err)
 			continue
 		}
-		break
+
+		if c.chainId == nil {
+			c.chainId = chainId
+		} else if c.chainId.Cmp(chainId) != 0 {
+			return fmt.Errorf("endpoint %s chainId %d not match chainId %d", e.url, chainId, c.chainId)
+		}
+		e.chainIdChecked = true
+		e.healthy = true
+	}
+	if c.chainId == nil {
+		return fmt.Errorf("get chainId err: no endpoint available")
 	}
 
-	// Construct tx opts, call opts, and nonce mechanism
-	if c.kp != nil {
-		opts, _, err := c.newTransactOpts(big.NewInt(0), c.gasLimit, c.maxGasPrice, chainId)
-		if err != nil {
-			return err
+	// use the first healthy endpoint
+	for i, e := range c.endpoints {
+		if e.healthy {
+			c.active = i
+			break
 		}
-		c.opts = opts
-		c.nonce = 0
+	}
+
+	// Construct tx opts, call opts, and nonce mechanism
+	if c.signer != nil {
+		c.opts = transactOpts(c.signer, c.chainId)
+		c.opts.GasLimit = c.gasLimit.Uint64()
+		c.opts.GasPrice = c.maxGasPrice
+		c.nonces = NonceManagerOf(c.chainId, c.opts.From)
 	}
 	return nil
 }
 
-// newTransactOpts builds the TransactOpts for the connection's keypair.
-func (c *Client) newTransactOpts(value, gasLimit, gasPrice *big.Int, chainId *big.Int) (*bind.TransactOpts, uint64, error) {
-	privateKey := c.kp.PrivateKey()
-	address := crypto.PubkeyToAddress(privateKey.PublicKey)
+// Signer returns the signer of the txs, nil for a read only client.
+func (c *Client) Signer() Signer {
+	return c.signer
+}
 
-	nonce, err := c.conn.NonceAt(context.Background(), address, nil)
-	if err != nil {
-		return nil, 0, err
-	}
-	opts, err := bind.NewKeyedTransactorWithChainID(privateKey, chainId)
-	if err != nil {
-		return nil, 0, err
-	}
-	opts.Nonce = big.NewInt(int64(nonce))
-	opts.Value = value
-	opts.GasLimit = uint64(gasLimit.Int64())
-	opts.GasPrice = gasPrice
-	opts.Context = context.Background()
+// Client returns the connection of the active endpoint.
+func (c *Client) Client() *ethclient.Client {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+	return c.endpoints[c.active].conn
+}
 
-	return opts, nonce, nil
+// Endpoint returns the url of the active endpoint.
+func (c *Client) Endpoint() string {
+	c.connLock.RLock()
+	defer c.connLock.RUnlock()
+	return c.endpoints[c.active].url
 }
 
-func (c *Client) Keypair() *secp256k1.Keypair {
-	return c.kp
+// chainName is the chain label of the metrics.
+func (c *Client) chainName() string {
+	return metrics.ChainName(c.chainId)
 }
 
-func (c *Client) Client() *ethclient.Client {
-	return c.conn
+func (c *Client) ChainID() *big.Int {
+	return new(big.Int).Set(c.chainId)
+}
+
+// Close stops the health check of the endpoints.
+func (c *Client) Close() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
 }
 
+// Opts returns the opts template of the keypair, only to be read. Txs are
+// sent with Transact.
 func (c *Client) Opts() *bind.TransactOpts {
 	return c.opts
 }
 
 func (c *Client) safeEstimateGas(ctx context.Context) (*big.Int, error) {
-	gasPrice, err := c.conn.SuggestGasPrice(context.TODO())
+	gasPrice, err := c.SuggestGasPrice(context.TODO())
 	if err != nil {
 		return nil, err
 	}
@@ -154,43 +210,62 @@ func (c *Client) safeEstimateGas(ctx context.Context) (*big.Int, error) {
 	return gasPrice, nil
 }
 
-// LockAndUpdateOpts acquires a lock on the opts before updating the nonce
-// and gas price.
-func (c *Client) LockAndUpdateOpts(gasLimit, value *big.Int) error {
-	c.optsLock.Lock()
-
-	gasPrice, err := c.safeEstimateGas(context.TODO())
-	if err != nil {
-		c.optsLock.Unlock()
-		return err
+// Transact sends one tx with send, which signs it with the opts it is given,
+// such as a binding method. The opts are a copy made for this tx only, holding
+// a nonce reserved from the NonceManager of the account and the current gas
+// price, or the fee caps of dynamic fee transactions. The opts lock is held
+// until send returns, so concurrent senders never share opts or a nonce, and a
+// nonce not sent is released. gasLimit is the gas limit of the tx as is, a
+// caller sending an estimate adds its margin, such as DefaultExtraGasLimit.
+// It must be positive. A nil value sends no ether.
+func (c *Client) Transact(gasLimit, value *big.Int, send func(opts *bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	if c.opts == nil {
+		return nil, fmt.Errorf("client has no keypair")
 	}
-	c.opts.GasPrice = gasPrice
-
-	nonce, err := c.conn.PendingNonceAt(context.Background(), c.opts.From)
-	if err != nil {
-		c.optsLock.Unlock()
-		return err
+	if gasLimit == nil || gasLimit.Sign() <= 0 {
+		return nil, ErrZeroGasLimit
 	}
-	c.opts.Nonce.SetUint64(nonce)
+	c.optsLock.Lock()
+	defer c.optsLock.Unlock()
 
-	if gasLimit.Uint64() == 0 {
-		c.opts.GasLimit = DefaultGasLimit.Uint64()
+	opts := *c.opts
+	opts.Value = new(big.Int)
+	if value != nil {
+		opts.Value.Set(value)
+	}
+	opts.GasLimit = gasLimit.Uint64()
+	if c.legacyGasPrice {
+		gasPrice, err := c.safeEstimateGas(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		opts.GasPrice = gasPrice
+		opts.GasFeeCap = nil
+		opts.GasTipCap = nil
 	} else {
-		c.opts.GasLimit = new(big.Int).Add(gasLimit, DefaultExtraGasLimit).Uint64()
+		feeCap, tipCap, err := c.safeDynamicFee(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		opts.GasPrice = nil
+		opts.GasFeeCap = feeCap
+		opts.GasTipCap = tipCap
 	}
 
-	c.opts.Value = value
-	return nil
-}
+	nonce, err := c.nonces.Next(c)
+	if err != nil {
+		return nil, err
+	}
+	opts.Nonce = new(big.Int).SetUint64(nonce)
+	// SendTransaction has marked the nonce in flight if sent, otherwise it is released
+	defer c.nonces.Release(nonce)
 
-func (c *Client) UnlockOpts() {
-	c.opts.Value = big.NewInt(0)
-	c.optsLock.Unlock()
+	return send(&opts)
 }
 
 // LatestBlock returns the latest block from the current chain
 func (c *Client) LatestBlock() (*big.Int, error) {
-	header, err := c.conn.HeaderByNumber(context.Background(), nil)
+	header, err := c.HeaderByNumber(context.Background(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -200,7 +275,7 @@ func (c *Client) LatestBlock() (*big.Int, error) {
 
 // LatestBlock returns the latest block from the current chain
 func (c *Client) LatestBlockTimestamp() (uint64, error) {
-	header, err := c.conn.HeaderByNumber(context.Background(), nil)
+	header, err := c.HeaderByNumber(context.Background(), nil)
 	if err != nil {
 		return 0, err
 	}
@@ -209,7 +284,7 @@ func (c *Client) LatestBlockTimestamp() (uint64, error) {
 }
 
 func (c *Client) LatestBlockAndTimestamp() (uint64, uint64, error) {
-	header, err := c.conn.HeaderByNumber(context.Background(), nil)
+	header, err := c.HeaderByNumber(context.Background(), nil)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -219,7 +294,7 @@ func (c *Client) LatestBlockAndTimestamp() (uint64, uint64, error) {
 
 // EnsureHasBytecode asserts if contract code exists at the specified address
 func (c *Client) EnsureHasBytecode(addr common.Address) error {
-	code, err := c.conn.CodeAt(context.Background(), addr, nil)
+	code, err := c.CodeAt(context.Background(), addr, nil)
 	if err != nil {
 		return err
 	}
@@ -231,13 +306,25 @@ func (c *Client) EnsureHasBytecode(addr common.Address) error {
 }
 
 func (c *Client) TransactionReceipt(hash common.Hash) (*types.Receipt, error) {
-	return c.conn.TransactionReceipt(context.Background(), hash)
+	defer metrics.ObserveRpc(c.chainName(), "eth_getTransactionReceipt", time.Now())
+	conn := c.Client()
+	receipt, err := conn.TransactionReceipt(context.Background(), hash)
+	c.checkErr(conn, err)
+	return receipt, err
 }
 
 func (c *Client) TransactionByHash(hash common.Hash) (tx *types.Transaction, isPending bool, err error) {
-	return c.conn.TransactionByHash(context.Background(), hash)
+	defer metrics.ObserveRpc(c.chainName(), "eth_getTransactionByHash", time.Now())
+	conn := c.Client()
+	tx, isPending, err = conn.TransactionByHash(context.Background(), hash)
+	c.checkErr(conn, err)
+	return tx, isPending, err
 }
 
 func (c *Client) TransactionSender(ctx context.Context, tx *types.Transaction, block common.Hash, index uint) (common.Address, error) {
-	return c.conn.TransactionSender(ctx, tx, block, index)
+	defer metrics.ObserveRpc(c.chainName(), "eth_getTransactionByBlockHashAndIndex", time.Now())
+	conn := c.Client()
+	sender, err := conn.TransactionSender(ctx, tx, block, index)
+	c.checkErr(conn, err)
+	return sender, err
 }
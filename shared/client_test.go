@@ -10,7 +10,7 @@ import (
 )
 
 func TestClient(t *testing.T) {
-	client, err := shared.NewClient("https://data-seed-prebsc-1-s2.binance.org:8545", nil, nil, nil)
+	client, err := shared.NewClient([]string{"https://data-seed-prebsc-1-s2.binance.org:8545"}, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,69 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	"rmatic-relay/pkg/config"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// ParseConfirmBlocks parses a confirmation depth, either a block count or
+// config.ConfirmFinalized.
+func ParseConfirmBlocks(value string) (depth uint64, finalized bool, err error) {
+	if value == config.ConfirmFinalized {
+		return 0, true, nil
+	}
+	depth, err = strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("confirm blocks should be a block count or %s: %s", config.ConfirmFinalized, value)
+	}
+	return depth, false, nil
+}
+
+// SetConfirmBlocks sets the confirmation depth of ConfirmedBlock, see ParseConfirmBlocks.
+func (c *Client) SetConfirmBlocks(value string) error {
+	depth, finalized, err := ParseConfirmBlocks(value)
+	if err != nil {
+		return err
+	}
+	c.confirmBlocks = depth
+	c.confirmFinalized = finalized
+	return nil
+}
+
+// ConfirmedBlock returns the newest block deep enough to be safe from reorgs,
+// latest - depth or the finalized block.
+func (c *Client) ConfirmedBlock() (*big.Int, error) {
+	if c.confirmFinalized {
+		header, err := c.HeaderByNumber(context.Background(), big.NewInt(int64(rpc.FinalizedBlockNumber)))
+		if err != nil {
+			return nil, err
+		}
+		return header.Number, nil
+	}
+
+	latest, err := c.LatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	if latest.Uint64() < c.confirmBlocks {
+		return big.NewInt(0), nil
+	}
+	return new(big.Int).SetUint64(latest.Uint64() - c.confirmBlocks), nil
+}
+
+// IsConfirmed reports whether block is at or below ConfirmedBlock.
+func (c *Client) IsConfirmed(block uint64) (bool, error) {
+	confirmed, err := c.ConfirmedBlock()
+	if err != nil {
+		return false, err
+	}
+	return confirmed.Uint64() >= block, nil
+}
@@ -0,0 +1,28 @@
+package shared
+
+import "testing"
+
+func TestParseConfirmBlocks(t *testing.T) {
+	cases := []struct {
+		value     string
+		depth     uint64
+		finalized bool
+		err       bool
+	}{
+		{"0", 0, false, false},
+		{"64", 64, false, false},
+		{"finalized", 0, true, false},
+		{"-1", 0, false, true},
+		{"safe", 0, false, true},
+	}
+	for _, c := range cases {
+		depth, finalized, err := ParseConfirmBlocks(c.value)
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected err %v", c.value, err)
+			continue
+		}
+		if depth != c.depth || finalized != c.finalized {
+			t.Errorf("%s: got %d %t, want %d %t", c.value, depth, finalized, c.depth, c.finalized)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// UnsignedTx builds the unsigned tx that Transact would send to the contract
+// at to with data, without signing or sending it.
+func (c *Client) UnsignedTx(to common.Address, data []byte, gasLimit, value *big.Int) (*types.Transaction, error) {
+	return c.Transact(gasLimit, value, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		if opts.GasPrice != nil {
+			return types.NewTx(&types.LegacyTx{
+				Nonce:    opts.Nonce.Uint64(),
+				GasPrice: opts.GasPrice,
+				Gas:      opts.GasLimit,
+				To:       &to,
+				Value:    opts.Value,
+				Data:     data,
+			}), nil
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   c.ChainID(),
+			Nonce:     opts.Nonce.Uint64(),
+			GasTipCap: opts.GasTipCap,
+			GasFeeCap: opts.GasFeeCap,
+			Gas:       opts.GasLimit,
+			To:        &to,
+			Value:     opts.Value,
+			Data:      data,
+		}), nil
+	})
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	HealthCheckInterval = time.Second * 30
+	HealthCheckTimeout  = time.Second * 10
+	// an endpoint whose head is older than MaxHeadAge is stale
+	MaxHeadAge = time.Minute * 3
+	// an endpoint lagging more than MaxHeadLag blocks behind the best endpoint is stale
+	MaxHeadLag = uint64(20)
+)
+
+type endpoint struct {
+	url            string
+	conn           *ethclient.Client
+	chainIdChecked bool
+	// chainIdMismatch endpoints are never used again
+	chainIdMismatch bool
+	healthy         bool
+}
+
+func (c *Client) healthCheckLoop() {
+	ticker := time.NewTicker(HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.healthCheck()
+		}
+	}
+}
+
+// healthCheck refreshes the health of every endpoint and switches to the first
+// healthy endpoint in order when it differs from the active one.
+func (c *Client) healthCheck() {
+	heads := make([]uint64, len(c.endpoints))
+	healthy := make([]bool, len(c.endpoints))
+	bestHead := uint64(0)
+	for i, e := range c.endpoints {
+		head, err := c.checkEndpoint(e)
+		if err != nil {
+			logrus.Warnf("endpoint %s health check failed: %s", e.url, err)
+			continue
+		}
+		heads[i] = head
+		healthy[i] = true
+		if head > bestHead {
+			bestHead = head
+		}
+	}
+
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	for i, e := range c.endpoints {
+		if healthy[i] && bestHead-heads[i] > MaxHeadLag {
+			logrus.Warnf("endpoint %s lagging: head %d best head %d", e.url, heads[i], bestHead)
+			healthy[i] = false
+		}
+		e.healthy = healthy[i]
+	}
+	for i := range c.endpoints {
+		if healthy[i] {
+			c.switchTo(i)
+			return
+		}
+	}
+	logrus.Errorf("no healthy endpoint, keep using %s", c.endpoints[c.active].url)
+}
+
+// checkEndpoint checks the chain ID and head freshness of e and returns its head.
+// The rpc calls run without connLock, the fields of e are read and written under it.
+func (c *Client) checkEndpoint(e *endpoint) (uint64, error) {
+	c.connLock.RLock()
+	conn, chainIdChecked, chainIdMismatch := e.conn, e.chainIdChecked, e.chainIdMismatch
+	c.connLock.RUnlock()
+	if chainIdMismatch {
+		return 0, errors.New("chainId mismatch")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HealthCheckTimeout)
+	defer cancel()
+
+	if conn == nil {
+		dialed, err := ethclient.DialContext(ctx, e.url)
+		if err != nil {
+			return 0, err
+		}
+		conn = dialed
+		c.connLock.Lock()
+		e.conn = conn
+		c.connLock.Unlock()
+	}
+	if !chainIdChecked {
+		chainId, err := conn.ChainID(ctx)
+		if err != nil {
+			return 0, err
+		}
+		mismatch := chainId.Cmp(c.chainId) != 0
+		c.connLock.Lock()
+		e.chainIdMismatch = mismatch
+		e.chainIdChecked = !mismatch
+		c.connLock.Unlock()
+		if mismatch {
+			logrus.Errorf("endpoint %s chainId %d not match chainId %d, disabled", e.url, chainId, c.chainId)
+			return 0, errors.New("chainId mismatch")
+		}
+	}
+
+	header, err := conn.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	headTime := time.Unix(int64(header.Time), 0)
+	if age := time.Since(headTime); age > MaxHeadAge {
+		return 0, errors.New("head is stale, age " + age.Round(time.Second).String())
+	}
+	return header.Number.Uint64(), nil
+}
+
+// checkErr fails over to another endpoint when err shows that conn is unreachable.
+func (c *Client) checkErr(conn *ethclient.Client, err error) {
+	if !isConnErr(err) {
+		return
+	}
+
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	if c.endpoints[c.active].conn != conn {
+		// already switched by another caller
+		return
+	}
+	logrus.Warnf("endpoint %s failed: %s", c.endpoints[c.active].url, err)
+	c.endpoints[c.active].healthy = false
+	for i := 1; i < len(c.endpoints); i++ {
+		next := (c.active + i) % len(c.endpoints)
+		if c.endpoints[next].healthy {
+			c.switchTo(next)
+			return
+		}
+	}
+}
+
+// switchTo must be called with connLock held.
+func (c *Client) switchTo(i int) {
+	if i == c.active {
+		return
+	}
+	logrus.Warnf("switch endpoint from %s to %s", c.endpoints[c.active].url, c.endpoints[i].url)
+	c.active = i
+}
+
+// isConnErr reports whether err comes from the transport rather than from a
+// response of the node, e.g. an execution revert.
+func isConnErr(err error) bool {
+	if err == nil || errors.Is(err, ethereum.NotFound) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.StatusCode == 429 || httpErr.StatusCode >= 500
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, rpc.ErrClientQuit)
+}
@@ -0,0 +1,75 @@
+package shared_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"rmatic-relay/shared"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newFakeNode serves eth_chainId and eth_getTransactionCount, and answers 500 once down is set.
+func newFakeNode(t *testing.T, chainId string, down *atomic.Bool) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		result := "0x1"
+		if req.Method == "eth_chainId" {
+			result = chainId
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClientFailover(t *testing.T) {
+	var downA, downB atomic.Bool
+	nodeA := newFakeNode(t, "0x1", &downA)
+	nodeB := newFakeNode(t, "0x1", &downB)
+
+	client, err := shared.NewClient([]string{nodeA.URL, nodeB.URL}, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if client.Endpoint() != nodeA.URL {
+		t.Fatalf("active endpoint %s, want %s", client.Endpoint(), nodeA.URL)
+	}
+
+	downA.Store(true)
+	if _, err := client.PendingNonceAt(context.Background(), common.Address{}); err == nil {
+		t.Fatal("expected error from down endpoint")
+	}
+	if client.Endpoint() != nodeB.URL {
+		t.Fatalf("active endpoint %s, want %s", client.Endpoint(), nodeB.URL)
+	}
+	if _, err := client.PendingNonceAt(context.Background(), common.Address{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientChainIdMismatch(t *testing.T) {
+	var up atomic.Bool
+	nodeA := newFakeNode(t, "0x1", &up)
+	nodeB := newFakeNode(t, "0x5", &up)
+
+	if _, err := shared.NewClient([]string{nodeA.URL, nodeB.URL}, nil, nil, nil); err == nil {
+		t.Fatal("expected chainId mismatch error")
+	}
+}
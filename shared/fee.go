@@ -0,0 +1,93 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"rmatic-relay/pkg/metrics"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// number of recent blocks and the tip percentile read from eth_feeHistory
+	FeeHistoryBlocks           = uint64(20)
+	FeeHistoryRewardPercentile = float64(60)
+	// the fee cap covers this many times the next base fee
+	BaseFeeMultiplier = big.NewInt(2)
+)
+
+func (c *Client) FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error) {
+	defer metrics.ObserveRpc(c.chainName(), "eth_feeHistory", time.Now())
+	conn := c.Client()
+	feeHistory, err := conn.FeeHistory(ctx, blockCount, lastBlock, rewardPercentiles)
+	c.checkErr(conn, err)
+	return feeHistory, err
+}
+
+// SetLegacyGasPrice makes the client send legacy gas price transactions
+// instead of dynamic fee transactions.
+func (c *Client) SetLegacyGasPrice(legacy bool) {
+	c.optsLock.Lock()
+	defer c.optsLock.Unlock()
+	c.legacyGasPrice = legacy
+}
+
+// safeDynamicFee returns the fee cap and tip cap of a dynamic fee transaction,
+// built from eth_feeHistory percentiles and capped by maxGasPrice.
+func (c *Client) safeDynamicFee(ctx context.Context) (feeCap, tipCap *big.Int, err error) {
+	feeHistory, err := c.FeeHistory(ctx, FeeHistoryBlocks, nil, []float64{FeeHistoryRewardPercentile})
+	if err != nil {
+		return nil, nil, err
+	}
+	suggestedTip, err := c.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return calDynamicFee(feeHistory, suggestedTip, c.maxGasPrice)
+}
+
+// calDynamicFee uses the median of the per block tip percentiles, at least
+// suggestedTip, and a fee cap of BaseFeeMultiplier times the next base fee plus the tip.
+func calDynamicFee(feeHistory *ethereum.FeeHistory, suggestedTip, maxGasPrice *big.Int) (feeCap, tipCap *big.Int, err error) {
+	if len(feeHistory.BaseFee) == 0 {
+		return nil, nil, fmt.Errorf("fee history has no base fee")
+	}
+	// the last base fee is the one of the next block
+	nextBaseFee := feeHistory.BaseFee[len(feeHistory.BaseFee)-1]
+
+	tips := make([]*big.Int, 0, len(feeHistory.Reward))
+	for _, reward := range feeHistory.Reward {
+		if len(reward) != 0 && reward[0] != nil {
+			tips = append(tips, reward[0])
+		}
+	}
+	tipCap = new(big.Int)
+	if len(tips) != 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		tipCap.Set(tips[len(tips)/2])
+	}
+	if suggestedTip != nil && tipCap.Cmp(suggestedTip) < 0 {
+		tipCap.Set(suggestedTip)
+	}
+
+	feeCap = new(big.Int).Mul(nextBaseFee, BaseFeeMultiplier)
+	feeCap.Add(feeCap, tipCap)
+
+	if feeCap.Cmp(maxGasPrice) > 0 {
+		if nextBaseFee.Cmp(maxGasPrice) > 0 {
+			logrus.Warnf("next base fee %s exceeds max gas price %s", nextBaseFee, maxGasPrice)
+		}
+		feeCap = new(big.Int).Set(maxGasPrice)
+	}
+	if tipCap.Cmp(feeCap) > 0 {
+		tipCap.Set(feeCap)
+	}
+	return feeCap, tipCap, nil
+}
@@ -0,0 +1,45 @@
+package shared
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+)
+
+func TestCalDynamicFee(t *testing.T) {
+	gwei := func(n int64) *big.Int { return new(big.Int).Mul(big.NewInt(n), big.NewInt(1e9)) }
+	feeHistory := &ethereum.FeeHistory{
+		Reward:  [][]*big.Int{{gwei(1)}, {gwei(3)}, {gwei(2)}},
+		BaseFee: []*big.Int{gwei(10), gwei(11), gwei(12), gwei(20)},
+	}
+
+	feeCap, tipCap, err := calDynamicFee(feeHistory, gwei(1), gwei(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tipCap.Cmp(gwei(2)) != 0 || feeCap.Cmp(gwei(42)) != 0 {
+		t.Fatalf("feeCap %s tipCap %s", feeCap, tipCap)
+	}
+
+	// suggested tip is the floor
+	_, tipCap, _ = calDynamicFee(feeHistory, gwei(30), gwei(100))
+	if tipCap.Cmp(gwei(30)) != 0 {
+		t.Fatalf("tipCap %s", tipCap)
+	}
+
+	// capped by max gas price
+	feeCap, tipCap, _ = calDynamicFee(feeHistory, gwei(30), gwei(25))
+	if feeCap.Cmp(gwei(25)) != 0 || tipCap.Cmp(gwei(25)) != 0 {
+		t.Fatalf("feeCap %s tipCap %s", feeCap, tipCap)
+	}
+}
+
+func TestBumpFee(t *testing.T) {
+	if bumped := bumpFee(big.NewInt(100)); bumped.Cmp(big.NewInt(115)) != 0 {
+		t.Fatalf("bumped %s", bumped)
+	}
+	if bumped := bumpFee(big.NewInt(1)); bumped.Cmp(big.NewInt(2)) != 0 {
+		t.Fatalf("bumped %s", bumped)
+	}
+}
@@ -0,0 +1,335 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// NonceGapInterval is how often the nonce gaps of a client are filled.
+	NonceGapInterval = time.Minute
+	// NonceDropTimeout is how long a nonce in flight may stay at or above the
+	// pending nonce before the node is asked for its txs. None found, the txs
+	// were dropped and the nonce is a gap.
+	NonceDropTimeout = 10 * time.Minute
+)
+
+// nonce states of a NonceManager
+const (
+	// handed out by Next, not sent yet
+	nonceReserved = iota
+	// sent, waiting to be mined
+	nonceInFlight
+	// handed out but never sent, a gap below the higher nonces in flight
+	nonceReleased
+)
+
+// nonceEntry is a nonce handed out by a NonceManager.
+type nonceEntry struct {
+	state int
+	// hashes of the txs sent with the nonce, replacements included
+	hashes []common.Hash
+	// checkedAt is when the nonce was last sent or found in the pool
+	checkedAt time.Time
+}
+
+// NonceReader reads the nonces of an account from the chain.
+type NonceReader interface {
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	TransactionByHash(hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+}
+
+// NonceManager hands out the nonces of one account on one chain. It is shared
+// by every client of the process signing with that account on that chain, see
+// NonceManagerOf, so that tasks sending concurrently never take the same nonce.
+// A nonce handed out but not sent is released: the highest is handed out
+// again, a lower one is a gap, reused by the next Next or filled with a cancel
+// tx by FillGaps.
+type NonceManager struct {
+	from   common.Address
+	lock   sync.Mutex
+	next   uint64
+	nonces map[uint64]*nonceEntry
+}
+
+var (
+	nonceManagers     = make(map[string]*NonceManager)
+	nonceManagersLock sync.Mutex
+)
+
+// NonceManagerOf returns the nonce manager of from on chainId, the same one on
+// every call.
+func NonceManagerOf(chainId *big.Int, from common.Address) *NonceManager {
+	nonceManagersLock.Lock()
+	defer nonceManagersLock.Unlock()
+
+	key := fmt.Sprintf("%s/%s", chainId.String(), from.String())
+	m, exist := nonceManagers[key]
+	if !exist {
+		m = &NonceManager{from: from, nonces: make(map[uint64]*nonceEntry)}
+		nonceManagers[key] = m
+	}
+	return m
+}
+
+// sync drops the nonces already mined and skips the nonces used outside of
+// the manager. A gap below the pending nonce is taken by a tx the node holds,
+// such as one whose send failed ambiguously, so it is in flight and never
+// cancelled. A nonce in flight at or above the pending nonce for longer than
+// NonceDropTimeout whose txs the node no longer holds is a gap. The lock must
+// be held.
+func (m *NonceManager) sync(reader NonceReader) (mined uint64, err error) {
+	mined, err = reader.NonceAt(context.Background(), m.from, nil)
+	if err != nil {
+		return 0, err
+	}
+	pending, err := reader.PendingNonceAt(context.Background(), m.from)
+	if err != nil {
+		return 0, err
+	}
+	for nonce, e := range m.nonces {
+		switch {
+		case nonce < mined:
+			delete(m.nonces, nonce)
+		case nonce < pending && e.state == nonceReleased:
+			e.state = nonceInFlight
+			e.checkedAt = time.Now()
+		case nonce >= pending && e.state == nonceInFlight && time.Since(e.checkedAt) > NonceDropTimeout:
+			e.checkedAt = time.Now()
+			if !holdsTx(reader, e.hashes) {
+				e.state = nonceReleased
+				logrus.WithFields(logrus.Fields{
+					"account": m.from.String(),
+					"nonce":   nonce,
+				}).Warn("txs of nonce dropped by the node, nonce is a gap")
+			}
+		}
+	}
+	if m.next < pending {
+		m.next = pending
+	}
+	return mined, nil
+}
+
+// holdsTx reports whether the node knows one of the txs hashes, pending or
+// mined. An error reading one counts as held, a tx is never taken as dropped
+// on a doubt.
+func holdsTx(reader NonceReader, hashes []common.Hash) bool {
+	for _, hash := range hashes {
+		_, _, err := reader.TransactionByHash(hash)
+		if err == nil || !errors.Is(err, ethereum.NotFound) {
+			return true
+		}
+	}
+	return false
+}
+
+// Next reserves the next nonce to send with, the lowest gap if any.
+func (m *NonceManager) Next(reader NonceReader) (uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := m.sync(reader); err != nil {
+		return 0, err
+	}
+	if gaps := m.gaps(); len(gaps) != 0 {
+		m.nonces[gaps[0]].state = nonceReserved
+		return gaps[0], nil
+	}
+	nonce := m.next
+	m.next++
+	m.nonces[nonce] = &nonceEntry{state: nonceReserved}
+	return nonce, nil
+}
+
+// Release gives back a reserved nonce that was not sent.
+func (m *NonceManager) Release(nonce uint64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.release(nonce)
+}
+
+func (m *NonceManager) release(nonce uint64) {
+	e, exist := m.nonces[nonce]
+	if !exist || e.state != nonceReserved {
+		return
+	}
+	e.state = nonceReleased
+	// released nonces on top are no gap, hand them out again
+	for m.next > 0 {
+		if e, exist := m.nonces[m.next-1]; !exist || e.state != nonceReleased {
+			break
+		}
+		m.next--
+		delete(m.nonces, m.next)
+	}
+}
+
+// Sent records the result of sending the tx hash with nonce. A reserved nonce
+// becomes in flight, or is released when the node rejected the tx. Any other
+// error, such as a timeout, can not tell whether the node took the tx, so the
+// nonce stays in flight as if sent. Resending a nonce already in flight adds
+// the hash of the replacement.
+func (m *NonceManager) Sent(nonce uint64, hash common.Hash, sendErr error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if isRejected(sendErr) {
+		m.release(nonce)
+		return
+	}
+	e, exist := m.nonces[nonce]
+	if !exist {
+		e = &nonceEntry{}
+		m.nonces[nonce] = e
+	}
+	e.state = nonceInFlight
+	e.hashes = append(e.hashes, hash)
+	e.checkedAt = time.Now()
+	if nonce >= m.next {
+		m.next = nonce + 1
+	}
+}
+
+// isRejected reports whether err is the node refusing a tx, a json rpc error
+// reply, so the tx is known not to be in its pool.
+func isRejected(err error) bool {
+	if err == nil {
+		return false
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	return !strings.Contains(err.Error(), "already known")
+}
+
+// InFlight returns the nonces sent and not yet known to be mined, in order.
+func (m *NonceManager) InFlight() []uint64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.withState(nonceInFlight)
+}
+
+// gaps returns the released nonces in order. The lock must be held.
+func (m *NonceManager) gaps() []uint64 {
+	return m.withState(nonceReleased)
+}
+
+func (m *NonceManager) withState(state int) []uint64 {
+	nonces := make([]uint64, 0)
+	for nonce, e := range m.nonces {
+		if e.state == state {
+			nonces = append(nonces, nonce)
+		}
+	}
+	sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+	return nonces
+}
+
+// reserveGaps reserves every gap not yet mined, for FillGaps to cancel.
+func (m *NonceManager) reserveGaps(reader NonceReader) ([]uint64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, err := m.sync(reader); err != nil {
+		return nil, err
+	}
+	gaps := m.gaps()
+	for _, nonce := range gaps {
+		m.nonces[nonce].state = nonceReserved
+	}
+	return gaps, nil
+}
+
+// FillGaps sends a cancel tx, a zero value transfer to itself, for every gap
+// of the nonces of c, so that the txs in flight above it can be mined. The
+// gaps are checked against the pending nonce first, a nonce the node already
+// holds a tx for is never cancelled.
+func (c *Client) FillGaps() error {
+	if c.nonces == nil {
+		return fmt.Errorf("client has no keypair")
+	}
+	gaps, err := c.nonces.reserveGaps(c)
+	if err != nil {
+		return err
+	}
+	for _, nonce := range gaps {
+		tx, err := c.cancelTx(nonce)
+		if err != nil {
+			c.nonces.Release(nonce)
+			return err
+		}
+		// SendTransaction records the nonce as in flight, or releases it when rejected
+		err = c.SendTransaction(context.Background(), tx)
+		if err != nil {
+			return fmt.Errorf("send cancel tx of nonce %d failed: %w", nonce, err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"nonce": nonce,
+			"tx":    tx.Hash().String(),
+		}).Warn("nonce gap filled with a cancel tx")
+	}
+	return nil
+}
+
+// cancelTx signs a zero value transfer to the sender itself with nonce.
+func (c *Client) cancelTx(nonce uint64) (*types.Transaction, error) {
+	from := c.opts.From
+	var txData types.TxData
+	if c.legacyGasPrice {
+		gasPrice, err := c.safeEstimateGas(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		txData = &types.LegacyTx{Nonce: nonce, GasPrice: gasPrice, Gas: 21000, To: &from, Value: big.NewInt(0)}
+	} else {
+		feeCap, tipCap, err := c.safeDynamicFee(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		txData = &types.DynamicFeeTx{ChainID: c.chainId, Nonce: nonce, GasTipCap: tipCap, GasFeeCap: feeCap, Gas: 21000, To: &from, Value: big.NewInt(0)}
+	}
+	return c.opts.Signer(from, types.NewTx(txData))
+}
+
+// StartNonceGapLoop fills the nonce gaps of c every NonceGapInterval until c
+// is closed. Only the process sending txs starts it, a dry run or a command
+// line client never fills gaps.
+func (c *Client) StartNonceGapLoop() {
+	if c.nonces == nil {
+		return
+	}
+	go c.nonceGapLoop()
+}
+
+func (c *Client) nonceGapLoop() {
+	ticker := time.NewTicker(NonceGapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			if err := c.FillGaps(); err != nil {
+				logrus.Warnf("fill nonce gaps on %s failed: %s", c.Endpoint(), err)
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// rejectedErr is a json rpc error reply of the node.
+type rejectedErr string
+
+func (e rejectedErr) Error() string  { return string(e) }
+func (e rejectedErr) ErrorCode() int { return -32000 }
+
+type fakeNonceReader struct {
+	mined, pending uint64
+	// pool holds the txs the node knows
+	pool map[common.Hash]bool
+}
+
+func (r *fakeNonceReader) NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error) {
+	return r.mined, nil
+}
+
+func (r *fakeNonceReader) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	return r.pending, nil
+}
+
+func (r *fakeNonceReader) TransactionByHash(hash common.Hash) (*types.Transaction, bool, error) {
+	if !r.pool[hash] {
+		return nil, false, ethereum.NotFound
+	}
+	return types.NewTx(&types.LegacyTx{}), true, nil
+}
+
+// newNonceManager returns the manager of from, dropped from the registry when
+// the test ends so that a rerun starts empty.
+func newNonceManager(t *testing.T, from common.Address) *NonceManager {
+	t.Cleanup(func() {
+		nonceManagersLock.Lock()
+		defer nonceManagersLock.Unlock()
+		delete(nonceManagers, fmt.Sprintf("%s/%s", big.NewInt(1).String(), from.String()))
+	})
+	return NonceManagerOf(big.NewInt(1), from)
+}
+
+func TestNonceManager(t *testing.T) {
+	reader := &fakeNonceReader{mined: 5, pending: 5}
+	m := newNonceManager(t, common.Address{1})
+	if m != NonceManagerOf(big.NewInt(1), common.Address{1}) {
+		t.Fatal("expected one manager per chain and account")
+	}
+
+	next := func(expect uint64) {
+		t.Helper()
+		nonce, err := m.Next(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce != expect {
+			t.Fatalf("expected nonce %d, got %d", expect, nonce)
+		}
+	}
+
+	// concurrent sends take distinct nonces although the pending nonce lags
+	next(5)
+	next(6)
+	next(7)
+	m.Sent(5, common.Hash{5}, nil)
+	m.Sent(7, common.Hash{7}, nil)
+
+	// a rejected send of the top nonce hands it out again
+	next(8)
+	m.Sent(8, common.Hash{8}, rejectedErr("transaction underpriced"))
+	next(8)
+	m.Release(8)
+
+	// a lower one is a gap, reused first
+	m.Release(6)
+	if gaps := m.gaps(); len(gaps) != 1 || gaps[0] != 6 {
+		t.Fatalf("expected gap 6, got %v", gaps)
+	}
+	next(6)
+	m.Sent(6, common.Hash{6}, nil)
+	if inFlight := m.InFlight(); len(inFlight) != 3 {
+		t.Fatalf("expected 3 nonces in flight, got %v", inFlight)
+	}
+
+	// a send failing ambiguously may have reached the node, the nonce is no gap
+	next(8)
+	m.Sent(8, common.Hash{8}, errors.New("context deadline exceeded"))
+	if inFlight := m.InFlight(); len(inFlight) != 4 {
+		t.Fatalf("expected 4 nonces in flight, got %v", inFlight)
+	}
+
+	// a gap the node holds a tx for is in flight, never cancelled
+	next(9)
+	next(10)
+	m.Sent(10, common.Hash{10}, nil)
+	m.Release(9)
+	reader.pending = 11
+	if gaps, err := m.reserveGaps(reader); err != nil || len(gaps) != 0 {
+		t.Fatalf("expected no gap below the pending nonce, got %v, %v", gaps, err)
+	}
+
+	// mined nonces are dropped, nonces used outside are skipped
+	reader.mined, reader.pending = 11, 13
+	next(13)
+	if inFlight := m.InFlight(); len(inFlight) != 0 {
+		t.Fatalf("expected no nonce in flight, got %v", inFlight)
+	}
+}
+
+func TestNonceManagerDropped(t *testing.T) {
+	defer func(timeout time.Duration) { NonceDropTimeout = timeout }(NonceDropTimeout)
+	NonceDropTimeout = 0
+
+	reader := &fakeNonceReader{mined: 3, pending: 3, pool: make(map[common.Hash]bool)}
+	m := newNonceManager(t, common.Address{2})
+	for nonce := uint64(3); nonce < 6; nonce++ {
+		if next, err := m.Next(reader); err != nil || next != nonce {
+			t.Fatalf("expected nonce %d, got %d, %v", nonce, next, err)
+		}
+		m.Sent(nonce, common.Hash{byte(nonce)}, nil)
+		reader.pool[common.Hash{byte(nonce)}] = true
+	}
+	reader.pending = 6
+
+	// the node drops the tx of nonce 3, the pending nonce falls back to it
+	// while 4 and 5 wait queued behind
+	delete(reader.pool, common.Hash{3})
+	reader.pending = 3
+	gaps, err := m.reserveGaps(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaps) != 1 || gaps[0] != 3 {
+		t.Fatalf("expected gap 3, got %v", gaps)
+	}
+	if inFlight := m.InFlight(); len(inFlight) != 2 || inFlight[0] != 4 {
+		t.Fatalf("expected 4 and 5 in flight, got %v", inFlight)
+	}
+
+	// the cancel tx takes the gap, nothing is dropped any more
+	m.Sent(3, common.Hash{30}, nil)
+	reader.pool[common.Hash{30}] = true
+	if gaps, err := m.reserveGaps(reader); err != nil || len(gaps) != 0 {
+		t.Fatalf("expected no gap, got %v, %v", gaps, err)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// nodes only accept a replacement paying at least 10% more, bump a bit further
+var ReplacementFeeBumpPercent = big.NewInt(15)
+
+var ErrReplacementCapped = errors.New("replacement fee reach max gas price")
+
+// ReplaceTransaction re-signs tx with the same nonce and a higher fee, at most
+// maxGasPrice, and sends it. ErrReplacementCapped is returned when the fee
+// can not be bumped enough under maxGasPrice.
+func (c *Client) ReplaceTransaction(tx *types.Transaction) (*types.Transaction, error) {
+	if c.opts == nil {
+		return nil, fmt.Errorf("client has no keypair")
+	}
+	c.optsLock.Lock()
+	defer c.optsLock.Unlock()
+
+	var txData types.TxData
+	switch tx.Type() {
+	case types.LegacyTxType:
+		minGasPrice := bumpFee(tx.GasPrice())
+		gasPrice, err := c.safeEstimateGas(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		if gasPrice.Cmp(minGasPrice) < 0 {
+			gasPrice = minGasPrice
+		}
+		if gasPrice.Cmp(c.maxGasPrice) > 0 {
+			return nil, ErrReplacementCapped
+		}
+		txData = &types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: gasPrice,
+			Gas:      tx.Gas(),
+			To:       tx.To(),
+			Value:    tx.Value(),
+			Data:     tx.Data(),
+		}
+	case types.DynamicFeeTxType:
+		minFeeCap := bumpFee(tx.GasFeeCap())
+		minTipCap := bumpFee(tx.GasTipCap())
+		feeCap, tipCap, err := c.safeDynamicFee(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		if feeCap.Cmp(minFeeCap) < 0 {
+			feeCap = minFeeCap
+		}
+		if tipCap.Cmp(minTipCap) < 0 {
+			tipCap = minTipCap
+		}
+		if feeCap.Cmp(c.maxGasPrice) > 0 || tipCap.Cmp(feeCap) > 0 {
+			return nil, ErrReplacementCapped
+		}
+		txData = &types.DynamicFeeTx{
+			ChainID:   c.chainId,
+			Nonce:     tx.Nonce(),
+			GasTipCap: tipCap,
+			GasFeeCap: feeCap,
+			Gas:       tx.Gas(),
+			To:        tx.To(),
+			Value:     tx.Value(),
+			Data:      tx.Data(),
+		}
+	default:
+		return nil, fmt.Errorf("unsupport tx type: %d", tx.Type())
+	}
+
+	signedTx, err := c.opts.Signer(c.opts.From, types.NewTx(txData))
+	if err != nil {
+		return nil, err
+	}
+	err = c.SendTransaction(context.TODO(), signedTx)
+	if err != nil {
+		return nil, err
+	}
+	return signedTx, nil
+}
+
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, new(big.Int).Add(big.NewInt(100), ReplacementFeeBumpPercent))
+	bumped.Div(bumped, big.NewInt(100))
+	// make sure tiny fees still grow
+	if bumped.Cmp(fee) <= 0 {
+		bumped.Add(fee, big.NewInt(1))
+	}
+	return bumped
+}
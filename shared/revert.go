@@ -0,0 +1,82 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// RevertError is returned for a mined transaction whose receipt status is failed.
+type RevertError struct {
+	TxHash      common.Hash
+	BlockNumber uint64
+	GasUsed     uint64
+	Reason      string
+}
+
+func (e *RevertError) Error() string {
+	return fmt.Sprintf("tx %s reverted in block %d, gas used: %d, reason: %s", e.TxHash.String(), e.BlockNumber, e.GasUsed, e.Reason)
+}
+
+// NewRevertError builds the RevertError of a failed receipt, recovering the
+// revert reason by replaying tx with eth_call at the receipt's block.
+func (c *Client) NewRevertError(tx *types.Transaction, receipt *types.Receipt) *RevertError {
+	reason, err := c.RevertReason(tx, receipt)
+	if err != nil {
+		reason = fmt.Sprintf("unknown (%s)", err)
+	}
+	return &RevertError{
+		TxHash:      receipt.TxHash,
+		BlockNumber: receipt.BlockNumber.Uint64(),
+		GasUsed:     receipt.GasUsed,
+		Reason:      reason,
+	}
+}
+
+// RevertReason replays tx with eth_call at the receipt's block and returns the revert reason.
+func (c *Client) RevertReason(tx *types.Transaction, receipt *types.Receipt) (string, error) {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return "", err
+	}
+	msg := ethereum.CallMsg{
+		From:  from,
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	_, err = c.CallContract(context.Background(), msg, receipt.BlockNumber)
+	if err == nil {
+		return "", fmt.Errorf("replay did not revert")
+	}
+	return revertReasonFromErr(err), nil
+}
+
+func revertReasonFromErr(err error) string {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if dataHex, ok := dataErr.ErrorData().(string); ok {
+			data, decodeErr := hexutil.Decode(dataHex)
+			if decodeErr == nil {
+				if reason, unpackErr := abi.UnpackRevert(data); unpackErr == nil {
+					return reason
+				}
+				if len(data) != 0 {
+					return fmt.Sprintf("%s (data: %s)", err.Error(), dataHex)
+				}
+			}
+		}
+	}
+	return err.Error()
+}
@@ -0,0 +1,28 @@
+package shared
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+type fakeDataError struct {
+	data string
+}
+
+func (e fakeDataError) Error() string          { return "execution reverted" }
+func (e fakeDataError) ErrorData() interface{} { return e.data }
+
+func TestRevertReasonFromErr(t *testing.T) {
+	// Error(string) "era not match"
+	data := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"000000000000000000000000000000000000000000000000000000000000000d" +
+		hexutil.Encode([]byte("era not match"))[2:] + "00000000000000000000000000000000000000"
+	if reason := revertReasonFromErr(fakeDataError{data: data}); reason != "era not match" {
+		t.Fatalf("reason %q", reason)
+	}
+	if reason := revertReasonFromErr(fakeDataError{data: "0x"}); reason != "execution reverted" {
+		t.Fatalf("reason %q", reason)
+	}
+}
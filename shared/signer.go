@@ -0,0 +1,185 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+)
+
+// SignTimeout bounds one request to an external signer, which may wait for a
+// manual approval.
+var SignTimeout = 2 * time.Minute
+
+// Signer signs the txs of one account for a Client.
+type Signer interface {
+	Address() common.Address
+	SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error)
+}
+
+// transactOpts builds the TransactOpts template signing with signer.
+func transactOpts(signer Signer, chainId *big.Int) *bind.TransactOpts {
+	from := signer.Address()
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != from {
+				return nil, bind.ErrNotAuthorized
+			}
+			return signer.SignTx(tx, chainId)
+		},
+		Nonce:   big.NewInt(0),
+		Value:   big.NewInt(0),
+		Context: context.Background(),
+	}
+}
+
+// KeystoreSigner signs with a key decrypted from the local keystore.
+type KeystoreSigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+func NewKeystoreSigner(kp *secp256k1.Keypair) *KeystoreSigner {
+	key := kp.PrivateKey()
+	return &KeystoreSigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+func (s *KeystoreSigner) Address() common.Address {
+	return s.address
+}
+
+func (s *KeystoreSigner) SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	return types.SignTx(tx, types.LatestSignerForChainID(chainId), s.key)
+}
+
+// ExternalSigner signs with the eth_signTransaction json-rpc of an external
+// signer such as Clef or web3signer, so that the key never enters the relayer.
+type ExternalSigner struct {
+	endpoint string
+	address  common.Address
+	client   *rpc.Client
+}
+
+// NewExternalSigner dials the signer at endpoint holding the key of address.
+func NewExternalSigner(endpoint string, address common.Address) (*ExternalSigner, error) {
+	client, err := rpc.Dial(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("dial signer %s failed: %w", endpoint, err)
+	}
+	return &ExternalSigner{endpoint: endpoint, address: address, client: client}, nil
+}
+
+func (s *ExternalSigner) Address() common.Address {
+	return s.address
+}
+
+// signTxArgs are the tx fields of eth_signTransaction.
+type signTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainId              *hexutil.Big    `json:"chainId"`
+}
+
+// SignTx asks the signer to sign tx. The signed tx is checked to be tx signed by
+// the account, the signer could otherwise alter it.
+func (s *ExternalSigner) SignTx(tx *types.Transaction, chainId *big.Int) (*types.Transaction, error) {
+	args := signTxArgs{
+		From:    s.address,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainId: (*hexutil.Big)(chainId),
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	default:
+		return nil, fmt.Errorf("unsupport tx type: %d", tx.Type())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), SignTimeout)
+	defer cancel()
+	var result json.RawMessage
+	if err := s.client.CallContext(ctx, &result, "eth_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("eth_signTransaction on %s failed: %w", s.endpoint, err)
+	}
+	raw, err := decodeSignTxResult(result)
+	if err != nil {
+		return nil, err
+	}
+	signedTx := new(types.Transaction)
+	if err := signedTx.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("decode signed tx failed: %w", err)
+	}
+
+	from, err := types.Sender(types.LatestSignerForChainID(chainId), signedTx)
+	if err != nil {
+		return nil, err
+	}
+	if from != s.address {
+		return nil, fmt.Errorf("tx signed by %s instead of %s", from.String(), s.address.String())
+	}
+	if !sameTx(tx, signedTx) {
+		return nil, fmt.Errorf("signed tx %s differs from the tx to sign", signedTx.Hash().String())
+	}
+	return signedTx, nil
+}
+
+// decodeSignTxResult reads the raw tx of the web3signer result, a hex string,
+// or of the Clef one, an object with a raw field.
+func decodeSignTxResult(result json.RawMessage) ([]byte, error) {
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(result, &raw); err == nil {
+		return raw, nil
+	}
+	var response struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(result, &response); err != nil || len(response.Raw) == 0 {
+		return nil, fmt.Errorf("unknown eth_signTransaction result: %s", string(result))
+	}
+	return response.Raw, nil
+}
+
+func sameTx(a, b *types.Transaction) bool {
+	if a.Type() != b.Type() || a.Nonce() != b.Nonce() || a.Gas() != b.Gas() ||
+		a.Value().Cmp(b.Value()) != 0 || string(a.Data()) != string(b.Data()) ||
+		a.GasFeeCap().Cmp(b.GasFeeCap()) != 0 || a.GasTipCap().Cmp(b.GasTipCap()) != 0 {
+		return false
+	}
+	if a.To() == nil || b.To() == nil {
+		return a.To() == b.To()
+	}
+	return *a.To() == *b.To()
+}
+
+// Close closes the connection to the signer.
+func (s *ExternalSigner) Close() {
+	s.client.Close()
+}
@@ -0,0 +1,122 @@
+package shared_test
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"rmatic-relay/shared"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newFakeSigner serves eth_signTransaction with key, answering like Clef or,
+// when web3signer is set, like web3signer. tamper alters the tx before signing.
+func newFakeSigner(t *testing.T, web3signer, tamper bool) (*httptest.Server, common.Address) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+			Params []struct {
+				To                   *common.Address `json:"to"`
+				Gas                  hexutil.Uint64  `json:"gas"`
+				MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+				MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+				Value                *hexutil.Big    `json:"value"`
+				Nonce                hexutil.Uint64  `json:"nonce"`
+				Data                 hexutil.Bytes   `json:"data"`
+				ChainId              *hexutil.Big    `json:"chainId"`
+			} `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Method != "eth_signTransaction" || len(req.Params) != 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		args := req.Params[0]
+		value := args.Value.ToInt()
+		if tamper {
+			value = big.NewInt(1e18)
+		}
+		tx, err := types.SignNewTx(key, types.LatestSignerForChainID(args.ChainId.ToInt()), &types.DynamicFeeTx{
+			ChainID:   args.ChainId.ToInt(),
+			Nonce:     uint64(args.Nonce),
+			GasTipCap: args.MaxPriorityFeePerGas.ToInt(),
+			GasFeeCap: args.MaxFeePerGas.ToInt(),
+			Gas:       uint64(args.Gas),
+			To:        args.To,
+			Value:     value,
+			Data:      args.Data,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		raw, _ := tx.MarshalBinary()
+		var result interface{} = map[string]interface{}{"raw": hexutil.Encode(raw), "tx": tx}
+		if web3signer {
+			result = hexutil.Encode(raw)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server, crypto.PubkeyToAddress(key.PublicKey)
+}
+
+func TestExternalSigner(t *testing.T) {
+	chainId := big.NewInt(137)
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainId,
+		Nonce:     3,
+		GasTipCap: big.NewInt(30e9),
+		GasFeeCap: big.NewInt(100e9),
+		Gas:       100000,
+		To:        &common.Address{1},
+		Value:     big.NewInt(0),
+		Data:      []byte{1, 2, 3},
+	})
+
+	for _, web3signer := range []bool{false, true} {
+		server, address := newFakeSigner(t, web3signer, false)
+		signer, err := shared.NewExternalSigner(server.URL, address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signedTx, err := signer.SignTx(tx, chainId)
+		if err != nil {
+			t.Fatalf("web3signer %t: %s", web3signer, err)
+		}
+		if signedTx.Nonce() != 3 || signedTx.Gas() != 100000 {
+			t.Errorf("web3signer %t: signed tx differs", web3signer)
+		}
+		signer.Close()
+	}
+
+	server, address := newFakeSigner(t, false, true)
+	signer, err := shared.NewExternalSigner(server.URL, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	if _, err := signer.SignTx(tx, chainId); err == nil {
+		t.Fatal("expected error for a tampered tx")
+	}
+
+	other, _ := newFakeSigner(t, false, false)
+	signer, err = shared.NewExternalSigner(other.URL, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signer.Close()
+	if _, err := signer.SignTx(tx, chainId); err == nil {
+		t.Fatal("expected error for a tx signed by another account")
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Stafi Protocol
+// SPDX-License-Identifier: LGPL-3.0-only
+
+package shared
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// SimulateTx runs the call of a tx from the client's account with eth_call
+// against the latest block, then estimates its gas. eth_estimateGas takes no
+// block here and runs at latest too, so both see the same state. The tx should
+// only be sent when no error is returned.
+func (c *Client) SimulateTx(to common.Address, data []byte, value *big.Int) (uint64, error) {
+	if c.opts == nil {
+		return 0, fmt.Errorf("client has no keypair")
+	}
+	msg := ethereum.CallMsg{
+		From:  c.opts.From,
+		To:    &to,
+		Value: value,
+		Data:  data,
+	}
+	_, err := c.CallContract(context.Background(), msg, nil)
+	if err != nil {
+		return 0, fmt.Errorf("simulate call failed: %s", revertReasonFromErr(err))
+	}
+	gas, err := c.EstimateGas(context.Background(), msg)
+	if err != nil {
+		return 0, fmt.Errorf("estimate gas failed: %s", revertReasonFromErr(err))
+	}
+	return gas, nil
+}
@@ -0,0 +1,146 @@
+package task
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"rmatic-relay/bindings/StakePortalRate"
+	"rmatic-relay/pkg/config"
+	"rmatic-relay/shared"
+)
+
+// Destination is a chain the sync rate task votes the rate to, with its own
+// client, StakePortalRate contract and gas settings.
+type Destination struct {
+	Name string
+
+	rpcEndpoints           []string
+	stakePortalRateAddress common.Address
+	// nil when the chain id is not checked
+	chainId        *big.Int
+	gasLimit       *big.Int
+	maxGasPrice    *big.Int
+	legacyGasPrice bool
+	confirmBlocks  string
+	minBalance     *big.Int
+
+	// need init on connect()
+	client          *shared.Client
+	stakePortalRate *stake_portal_rate.StakePortalRate
+
+	// busy is set while a sync of this destination is in flight
+	busy atomic.Bool
+	// queued is the latest rate dispatched and not yet synced, takenEra the
+	// era of the last rate taken to sync
+	queueLock sync.Mutex
+	queued    *queuedRate
+	takenEra  uint32
+	// unix time of the last successful sync, or of the connection
+	lastSynced atomic.Int64
+}
+
+// queuedRate is a rate of era waiting to be synced to a destination.
+type queuedRate struct {
+	rate *big.Int
+	era  uint32
+}
+
+func NewDestination(cfg config.Destination) (*Destination, error) {
+	gasLimit, maxGasPrice, err := parseGas(cfg.GasLimit, cfg.MaxGasPrice)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s: %w", cfg.Name, err)
+	}
+	minBalance, err := parseMinBalance(cfg.MinBalance)
+	if err != nil {
+		return nil, fmt.Errorf("destination %s: %w", cfg.Name, err)
+	}
+	d := &Destination{
+		Name:                   cfg.Name,
+		rpcEndpoints:           config.SplitEndpoints(cfg.RpcEndpoint),
+		stakePortalRateAddress: common.HexToAddress(cfg.StakePortalRateAddress),
+		gasLimit:               gasLimit,
+		maxGasPrice:            maxGasPrice,
+		legacyGasPrice:         cfg.GasPriceMode == config.GasPriceModeLegacy,
+		confirmBlocks:          cfg.ConfirmBlocks,
+		minBalance:             minBalance,
+	}
+	if len(cfg.ChainId) != 0 {
+		chainId, ok := new(big.Int).SetString(cfg.ChainId, 10)
+		if !ok {
+			return nil, fmt.Errorf("destination %s: chain id not integer: %s", cfg.Name, cfg.ChainId)
+		}
+		d.chainId = chainId
+	}
+	return d, nil
+}
+
+// connect dials the destination and binds its StakePortalRate contract.
+func (d *Destination) connect(signer shared.Signer) error {
+	client, err := shared.NewClient(d.rpcEndpoints, signer, d.gasLimit, d.maxGasPrice)
+	if err != nil {
+		return fmt.Errorf("destination %s: %w", d.Name, err)
+	}
+	if d.chainId != nil && d.chainId.Cmp(client.ChainID()) != 0 {
+		client.Close()
+		return fmt.Errorf("destination %s: chain id %s not match configured %s", d.Name, client.ChainID(), d.chainId)
+	}
+	client.SetLegacyGasPrice(d.legacyGasPrice)
+	if err := client.SetConfirmBlocks(d.confirmBlocks); err != nil {
+		client.Close()
+		return fmt.Errorf("destination %s: %w", d.Name, err)
+	}
+
+	stakePortalRate, err := stake_portal_rate.NewStakePortalRate(d.stakePortalRateAddress, client)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	d.client = client
+	d.stakePortalRate = stakePortalRate
+	d.lastSynced.Store(time.Now().Unix())
+	return nil
+}
+
+// checkSynced returns an error when d has not completed a successful sync
+// within maxAge.
+func (d *Destination) checkSynced(maxAge time.Duration) error {
+	lastSynced := time.Unix(d.lastSynced.Load(), 0)
+	if since := time.Since(lastSynced); since > maxAge {
+		return fmt.Errorf("destination %s has no successful sync for %s", d.Name, since.Round(time.Second))
+	}
+	return nil
+}
+
+// queueRate queues rate of era for d, replacing a queued rate of the same or an
+// earlier era. Only the latest rate is worth voting, a rate of an era before
+// the one last taken is dropped.
+func (d *Destination) queueRate(rate *big.Int, era uint32) {
+	d.queueLock.Lock()
+	defer d.queueLock.Unlock()
+	if (d.queued != nil && d.queued.era > era) || era < d.takenEra {
+		return
+	}
+	d.queued = &queuedRate{rate: rate, era: era}
+}
+
+// takeRate returns and clears the queued rate, nil if none.
+func (d *Destination) takeRate() *queuedRate {
+	d.queueLock.Lock()
+	defer d.queueLock.Unlock()
+	queued := d.queued
+	d.queued = nil
+	if queued != nil {
+		d.takenEra = queued.era
+	}
+	return queued
+}
+
+func (d *Destination) hasQueuedRate() bool {
+	d.queueLock.Lock()
+	defer d.queueLock.Unlock()
+	return d.queued != nil
+}
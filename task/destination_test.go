@@ -0,0 +1,26 @@
+package task
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestQueueRate(t *testing.T) {
+	d := &Destination{Name: "polygon"}
+
+	d.queueRate(big.NewInt(2), 12)
+	d.queueRate(big.NewInt(1), 11)
+	if queued := d.takeRate(); queued == nil || queued.era != 12 {
+		t.Fatalf("expected the rate of era 12 taken, got %v", queued)
+	}
+
+	// a late rate of an earlier era is dropped, the same era is synced again
+	d.queueRate(big.NewInt(1), 11)
+	if d.hasQueuedRate() {
+		t.Fatal("expected the rate of era 11 dropped after era 12 was taken")
+	}
+	d.queueRate(big.NewInt(2), 12)
+	if queued := d.takeRate(); queued == nil || queued.era != 12 {
+		t.Fatalf("expected the rate of era 12 queued again, got %v", queued)
+	}
+}
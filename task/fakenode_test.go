@@ -0,0 +1,218 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
+	"rmatic-relay/pkg/utils"
+	"rmatic-relay/shared"
+)
+
+// fakeNode is a json rpc node of one account holding a head, the mined nonce
+// and the receipts of the mined txs. A test mines and reorgs txs from onHead
+// and onSend, called with the lock held.
+type fakeNode struct {
+	t       *testing.T
+	chainId *big.Int
+
+	lock sync.Mutex
+	head uint64
+	// finalized is the block of the finalized tag, it never advances by itself
+	finalized uint64
+	nonce     uint64
+	receipts  map[common.Hash]*types.Receipt
+	sent      []*types.Transaction
+	// onHead is called on every request of the latest header, after the head
+	// advanced
+	onHead func(n *fakeNode)
+	// onSend is called on every eth_sendRawTransaction
+	onSend func(n *fakeNode, tx *types.Transaction)
+	// blockTime advances the head by time instead of by request when set
+	blockTime time.Duration
+	// started and startHead are the time and the head blockTime counts from
+	started   time.Time
+	startHead uint64
+	// failReceipts is the count of the next receipt requests answered by a
+	// bad gateway
+	failReceipts int
+}
+
+func newFakeNode(t *testing.T) (*fakeNode, *httptest.Server) {
+	n := &fakeNode{t: t, chainId: big.NewInt(80002), head: 100, receipts: make(map[common.Hash]*types.Receipt)}
+	server := httptest.NewServer(http.HandlerFunc(n.serve))
+	t.Cleanup(server.Close)
+	return n, server
+}
+
+// advanceEvery advances the head by one block every blockTime from now on.
+func (n *fakeNode) advanceEvery(blockTime time.Duration) {
+	n.blockTime = blockTime
+	n.started = time.Now()
+	n.startHead = n.head
+}
+
+// mine puts the receipt of tx into the next block.
+func (n *fakeNode) mine(tx *types.Transaction) {
+	n.head++
+	n.receipts[tx.Hash()] = &types.Receipt{
+		Type:              tx.Type(),
+		Status:            types.ReceiptStatusSuccessful,
+		CumulativeGasUsed: 21000,
+		Logs:              []*types.Log{},
+		TxHash:            tx.Hash(),
+		GasUsed:           21000,
+		EffectiveGasPrice: tx.GasFeeCap(),
+		BlockHash:         common.BigToHash(new(big.Int).SetUint64(n.head)),
+		BlockNumber:       new(big.Int).SetUint64(n.head),
+	}
+	n.nonce = tx.Nonce() + 1
+}
+
+func (n *fakeNode) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	n.lock.Lock()
+	if req.Method == "eth_getTransactionReceipt" && n.failReceipts > 0 {
+		n.failReceipts--
+		n.lock.Unlock()
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	result, err := n.call(req.Method, req.Params)
+	n.lock.Unlock()
+
+	resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "result": result}
+	if err != nil {
+		resp = map[string]interface{}{"jsonrpc": "2.0", "id": req.ID, "error": map[string]interface{}{"code": -32000, "message": err.Error()}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (n *fakeNode) call(method string, params []json.RawMessage) (interface{}, error) {
+	var first string
+	if len(params) != 0 {
+		json.Unmarshal(params[0], &first)
+	}
+	switch method {
+	case "eth_chainId":
+		return (*hexutil.Big)(n.chainId), nil
+	case "eth_getBlockByNumber":
+		number := n.head
+		switch first {
+		case "latest":
+			if n.blockTime == 0 {
+				n.head++
+			} else if head := n.startHead + uint64(time.Since(n.started)/n.blockTime); head > n.head {
+				n.head = head
+			}
+			if n.onHead != nil {
+				n.onHead(n)
+			}
+			number = n.head
+		case "finalized":
+			number = n.finalized
+		}
+		return &types.Header{
+			Number:     new(big.Int).SetUint64(number),
+			Difficulty: big.NewInt(0),
+			Time:       uint64(time.Now().Unix()),
+			BaseFee:    big.NewInt(10e9),
+		}, nil
+	case "eth_getTransactionReceipt":
+		return n.receipts[common.HexToHash(first)], nil
+	case "eth_getTransactionCount":
+		return hexutil.Uint64(n.nonce), nil
+	case "eth_sendRawTransaction":
+		tx := new(types.Transaction)
+		if err := tx.UnmarshalBinary(common.FromHex(first)); err != nil {
+			return nil, err
+		}
+		n.sent = append(n.sent, tx)
+		if n.onSend != nil {
+			n.onSend(n, tx)
+		}
+		return tx.Hash(), nil
+	case "eth_feeHistory":
+		return map[string]interface{}{
+			"oldestBlock":   (*hexutil.Big)(new(big.Int).SetUint64(n.head)),
+			"baseFeePerGas": []*hexutil.Big{(*hexutil.Big)(big.NewInt(10e9)), (*hexutil.Big)(big.NewInt(10e9))},
+			"gasUsedRatio":  []float64{0.5},
+			"reward":        [][]*hexutil.Big{{(*hexutil.Big)(big.NewInt(1e9))}},
+		}, nil
+	case "eth_maxPriorityFeePerGas", "eth_gasPrice":
+		return (*hexutil.Big)(big.NewInt(1e9)), nil
+	case "eth_getTransactionByHash":
+		return nil, nil
+	}
+	n.t.Errorf("fake node: unexpected method %s", method)
+	return nil, fmt.Errorf("method %s not found", method)
+}
+
+// newFakeNodeClient returns a client of the node at url signing with a new
+// key, whose reads are confirmed confirmBlocks blocks below the head.
+func newFakeNodeClient(t *testing.T, url string, maxGasPrice *big.Int, confirmBlocks string) *shared.Client {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := shared.NewClient([]string{url}, shared.NewKeystoreSigner(secp256k1.NewKeypair(*key)), nil, maxGasPrice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	if err := client.SetConfirmBlocks(confirmBlocks); err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// fastRetry shortens utils.RetryInterval for the test.
+func fastRetry(t *testing.T) {
+	interval := utils.RetryInterval
+	utils.RetryInterval = time.Millisecond
+	t.Cleanup(func() { utils.RetryInterval = interval })
+}
+
+// sendTx sends a zero value transfer of client to itself through Transact.
+func sendTx(t *testing.T, client *shared.Client) *types.Transaction {
+	from := client.Opts().From
+	tx, err := client.Transact(shared.DefaultGasLimit, nil, func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		tx, err := opts.Signer(from, types.NewTx(&types.DynamicFeeTx{
+			ChainID:   client.ChainID(),
+			Nonce:     opts.Nonce.Uint64(),
+			GasTipCap: opts.GasTipCap,
+			GasFeeCap: opts.GasFeeCap,
+			Gas:       opts.GasLimit,
+			To:        &from,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		return tx, client.SendTransaction(context.Background(), tx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
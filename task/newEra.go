@@ -7,27 +7,33 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"rmatic-relay/bindings/StakeManager"
+	"rmatic-relay/pkg/metrics"
+	"rmatic-relay/pkg/store"
+	"rmatic-relay/shared"
 )
 
 func (t *Task) handleNewEra() error {
-	latestCallOpts := bind.CallOpts{
-		Pending: false,
-		From:    [20]byte{},
-		Context: context.Background(),
+	confirmedCallOpts, err := t.ethConfirmedCallOpts()
+	if err != nil {
+		return err
 	}
 
-	currentEra, err := t.ethContractStakeManager.CurrentEra(&latestCallOpts)
+	currentEra, err := t.ethContractStakeManager.CurrentEra(confirmedCallOpts)
 	if err != nil {
 		return err
 	}
-	latestEra, err := t.ethContractStakeManager.LatestEra(&latestCallOpts)
+	latestEra, err := t.ethContractStakeManager.LatestEra(confirmedCallOpts)
 	if err != nil {
 		return err
 	}
+	metrics.CurrentEra.Set(float64(currentEra.Uint64()))
+	metrics.LatestEra.Set(float64(latestEra.Uint64()))
 
-	err = t.checkAndCallNewEra(currentEra, latestEra, &latestCallOpts)
+	err = t.checkAndCallNewEra(currentEra, latestEra)
 	if err != nil {
 		return err
 	}
@@ -35,7 +41,19 @@ func (t *Task) handleNewEra() error {
 	return nil
 }
 
-func (t *Task) checkAndCallNewEra(currentEra, latestEra *big.Int, latestCallOpts *bind.CallOpts) error {
+// ethConfirmedCallOpts pins reads to the confirmed block of eth.
+func (t *Task) ethConfirmedCallOpts() (*bind.CallOpts, error) {
+	confirmedBlock, err := t.ethClient.ConfirmedBlock()
+	if err != nil {
+		return nil, err
+	}
+	return &bind.CallOpts{
+		BlockNumber: confirmedBlock,
+		Context:     context.Background(),
+	}, nil
+}
+
+func (t *Task) checkAndCallNewEra(currentEra, latestEra *big.Int) error {
 	// case 0: currentEra==latestEra
 	// no need deal
 	if currentEra.Cmp(latestEra) == 0 {
@@ -47,40 +65,74 @@ func (t *Task) checkAndCallNewEra(currentEra, latestEra *big.Int, latestCallOpts
 	// vote newEra
 	willUseEra := new(big.Int).Add(latestEra, big.NewInt(1))
 
-	// check era
-	latestEra, err := t.ethContractStakeManager.LatestEra(latestCallOpts)
+	// check era at latest, the confirmed block lags behind a newEra already
+	// executed by another relay or by a tx of ours not yet confirmed
+	latestEra, err := t.ethContractStakeManager.LatestEra(&bind.CallOpts{Context: context.Background()})
 	if err != nil {
 		return err
 	}
+	if latestEra.Cmp(willUseEra) >= 0 {
+		logrus.Infof("newEra %d already executed at latest block, no need deal", willUseEra.Uint64())
+		return nil
+	}
 	if willUseEra.Cmp(new(big.Int).Add(latestEra, big.NewInt(1))) != 0 {
 		logrus.Debugf("willUseEra: %d not match latestEra: %d, no need deal", willUseEra.Int64(), latestEra.Int64())
 		return nil
 	}
 
-	// send tx
-	err = t.ethClient.LockAndUpdateOpts(t.gasLimit, big.NewInt(0))
+	// simulate before sending, a revert still costs gas
+	stakeManagerAbi, err := stake_manager.StakeManagerMetaData.GetAbi()
 	if err != nil {
 		return err
 	}
-	tx, err := t.ethContractStakeManager.NewEra(t.ethClient.Opts())
-	t.ethClient.UnlockOpts()
+	input, err := stakeManagerAbi.Pack("newEra")
 	if err != nil {
 		return err
 	}
+	gasLimit, err := t.simulateTx(t.ethClient, t.ethStakeManagerAddress, input, t.gasLimit)
+	if err != nil {
+		return errors.Wrapf(err, "newEra %d simulation failed", willUseEra.Uint64())
+	}
+
+	if t.dryRun {
+		return t.logDryRunTx(t.ethClient, fmt.Sprintf("newEra %d", willUseEra.Uint64()), t.ethStakeManagerAddress, input, gasLimit)
+	}
 
-	err = t.waitTxOnChain(tx.Hash(), t.ethClient)
+	// send tx
+	tx, err := t.ethClient.Transact(gasLimit, big.NewInt(0), t.ethContractStakeManager.NewEra)
+	if err != nil {
+		return err
+	}
+	key := store.NewEraKey(willUseEra.Uint64())
+	t.recordTx(key, t.ethClient, tx)
+
+	txHash, err := t.waitTxOnChain(key, []*types.Transaction{tx}, t.ethClient)
 	if err != nil {
+		var revertErr *shared.RevertError
+		if errors.As(err, &revertErr) {
+			logrus.Errorf("newEra %d tx reverted, reason: %s, gas used: %d", willUseEra.Uint64(), revertErr.Reason, revertErr.GasUsed)
+		}
 		return errors.Wrap(err, "waitTxOnChain failed")
 	}
+	if err := t.store.RecordEra(willUseEra.Uint64(), txHash); err != nil {
+		logrus.Warnf("store era %d failed: %s", willUseEra.Uint64(), err)
+	}
 
-	//wait until newEra executed
+	//wait until newEra executed at the confirmed block
 	retry := 0
 	for {
 		// wait 2h
 		if retry > 2*60*5 {
 			return fmt.Errorf("wait newEra %d executed failed", willUseEra.Uint64())
 		}
-		latestEra, err := t.ethContractStakeManager.LatestEra(latestCallOpts)
+		confirmedCallOpts, err := t.ethConfirmedCallOpts()
+		if err != nil {
+			logrus.Warnf("get confirmed block failed: %s", err.Error())
+			time.Sleep(12 * time.Second)
+			retry++
+			continue
+		}
+		latestEra, err := t.ethContractStakeManager.LatestEra(confirmedCallOpts)
 		if err != nil {
 			logrus.Warnf("get latestEra failed: %s", err.Error())
 			time.Sleep(12 * time.Second)
@@ -0,0 +1,141 @@
+package task
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/sirupsen/logrus"
+	"rmatic-relay/bindings/StakeManager"
+	"rmatic-relay/pkg/utils"
+)
+
+var (
+	// EventPollInterval is how often ExecuteNewEra is polled by block range.
+	EventPollInterval = 12 * time.Second
+	// MaxFilterBlocks bounds the block range of one FilterExecuteNewEra call.
+	MaxFilterBlocks uint64 = 5000
+)
+
+// executeNewEraHandler syncs the rate of every ExecuteNewEra event to the
+// destinations as soon as the event is confirmed, using the era and rate of the
+// event. Events are only polled with FilterExecuteNewEra over the confirmed
+// block range, every EventPollInterval: a subscription delivers them at the
+// head, where they would still have to wait for the confirmation depth. The
+// periodic comparison of syncRateHandler stays as the safety net.
+func (t *Task) executeNewEraHandler() {
+	logrus.Info("start execute new era event Handler")
+	ticker := time.NewTicker(EventPollInterval)
+	defer ticker.Stop()
+
+	// events before the start are covered by the periodic comparison
+	var nextBlock uint64
+	for {
+		confirmed, err := t.ethClient.ConfirmedBlock()
+		if err == nil {
+			nextBlock = confirmed.Uint64() + 1
+			break
+		}
+		logrus.Warnf("executeNewEraHandler get latest block failed: %s", err)
+		select {
+		case <-t.stop:
+			return
+		case <-time.After(utils.RetryInterval):
+		}
+	}
+	var lastEra uint64
+
+	handle := func(ev *stake_manager.StakeManagerExecuteNewEra) {
+		if ev.Raw.Removed || ev.Era.Uint64() <= lastEra {
+			return
+		}
+		err := t.checkEraRate(&bind.CallOpts{BlockNumber: new(big.Int).SetUint64(ev.Raw.BlockNumber)}, ev.Era, ev.Rate)
+		if err != nil {
+			logrus.Warnf("ExecuteNewEra event of era %d skipped: %s", ev.Era.Uint64(), err)
+			return
+		}
+		lastEra = ev.Era.Uint64()
+		logrus.WithFields(logrus.Fields{
+			"era":   ev.Era.Uint64(),
+			"rate":  ev.Rate.String(),
+			"block": ev.Raw.BlockNumber,
+			"tx":    ev.Raw.TxHash.String(),
+		}).Info("ExecuteNewEra event, sync rate")
+		t.dispatchRateTo(t.unvotedDestinations(ev.Era.Uint64()), ev.Rate, uint32(ev.Era.Uint64()))
+	}
+
+	for {
+		select {
+		case <-t.stop:
+			logrus.Info("execute new era event handler has stopped")
+			return
+		case <-ticker.C:
+		}
+
+		events, err := t.filterExecuteNewEra(nextBlock)
+		if err != nil {
+			logrus.Warnf("filterExecuteNewEra from %d failed: %s", nextBlock, err)
+			continue
+		}
+		for _, ev := range events.events {
+			handle(ev)
+		}
+		nextBlock = events.toBlock + 1
+	}
+}
+
+// unvotedDestinations returns the destinations whose last vote recorded in the
+// store is of an era before era. An event polled late, such as while catching
+// up, must not vote its rate over the rate of a later era.
+func (t *Task) unvotedDestinations(era uint64) []*Destination {
+	destinations := make([]*Destination, 0, len(t.destinations))
+	for _, d := range t.destinations {
+		if votedEra, ok := t.store.LastVotedEra(d.Name); ok && era <= votedEra {
+			logrus.Debugf("ExecuteNewEra event of era %d skipped on %s, era %d already voted", era, d.Name, votedEra)
+			continue
+		}
+		destinations = append(destinations, d)
+	}
+	return destinations
+}
+
+type executeNewEraEvents struct {
+	events  []*stake_manager.StakeManagerExecuteNewEra
+	toBlock uint64
+}
+
+// filterExecuteNewEra returns the ExecuteNewEra events from fromBlock up to the
+// confirmed block, at most MaxFilterBlocks blocks. toBlock is the last block searched.
+func (t *Task) filterExecuteNewEra(fromBlock uint64) (*executeNewEraEvents, error) {
+	confirmed, err := t.ethClient.ConfirmedBlock()
+	if err != nil {
+		return nil, err
+	}
+	ret := &executeNewEraEvents{toBlock: fromBlock - 1}
+	if confirmed.Uint64() < fromBlock {
+		return ret, nil
+	}
+	toBlock := confirmed.Uint64()
+	if toBlock-fromBlock+1 > MaxFilterBlocks {
+		toBlock = fromBlock + MaxFilterBlocks - 1
+	}
+
+	iter, err := t.ethContractStakeManager.FilterExecuteNewEra(&bind.FilterOpts{
+		Start:   fromBlock,
+		End:     &toBlock,
+		Context: context.Background(),
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	for iter.Next() {
+		ret.events = append(ret.events, iter.Event)
+	}
+	if err := iter.Error(); err != nil {
+		return nil, err
+	}
+	ret.toBlock = toBlock
+	return ret, nil
+}
@@ -0,0 +1,146 @@
+package task
+
+import (
+	"context"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/sirupsen/logrus"
+	"rmatic-relay/pkg/store"
+	"rmatic-relay/pkg/utils"
+	"rmatic-relay/shared"
+)
+
+func (task *Task) recordTx(key string, client *shared.Client, tx *types.Transaction) {
+	if err := task.store.RecordTx(key, client.ChainID(), tx); err != nil {
+		logrus.Warnf("store tx %s of %s failed: %s", tx.Hash().String(), key, err)
+	}
+}
+
+func (task *Task) setTxStatus(hash common.Hash, status string) {
+	if err := task.store.SetTxStatus(hash, status); err != nil {
+		logrus.Warnf("store status %s of tx %s failed: %s", status, hash.String(), err)
+	}
+}
+
+// inFlightTxs returns the txs of key left in flight by an earlier run, to be
+// waited for instead of sending a duplicate. Txs whose nonce was taken by
+// another tx are marked dropped and not returned. The newest one is broadcast
+// again, the node may have lost it.
+func (task *Task) inFlightTxs(key string, client *shared.Client) ([]*types.Transaction, error) {
+	txs, err := task.store.InFlightTxs(key, client.ChainID())
+	if err != nil || len(txs) == 0 {
+		return nil, err
+	}
+	for _, tx := range txs {
+		if _, err := client.TransactionReceipt(tx.Hash()); err == nil {
+			return txs, nil
+		}
+	}
+
+	nonce, err := client.NonceAt(context.Background(), client.Opts().From, nil)
+	if err != nil {
+		return nil, err
+	}
+	if nonce > txs[0].Nonce() {
+		logrus.Warnf("txs of %s dropped, nonce %d used by another tx", key, txs[0].Nonce())
+		for _, tx := range txs {
+			task.setTxStatus(tx.Hash(), store.TxStatusDropped)
+		}
+		return nil, nil
+	}
+
+	last := txs[len(txs)-1]
+	if err := client.SendTransaction(context.Background(), last); err != nil {
+		logrus.Debugf("broadcast resumed tx %s: %s", last.Hash().String(), err)
+	}
+	return txs, nil
+}
+
+// resumeNewEra waits for the newEra txs left in flight by an earlier run.
+func (task *Task) resumeNewEra() {
+	for _, key := range task.store.InFlightKeys(store.NewEraKeyPrefix, task.ethClient.ChainID()) {
+		era, err := store.ParseNewEraKey(key)
+		if err != nil {
+			logrus.Warnf("resume %s: %s", key, err)
+			continue
+		}
+		txs, err := task.inFlightTxs(key, task.ethClient)
+		if err != nil {
+			logrus.Warnf("resume %s: %s", key, err)
+			continue
+		}
+		if len(txs) == 0 {
+			continue
+		}
+		logrus.Infof("resume newEra %d tx %s", era, txs[len(txs)-1].Hash().String())
+		txHash, err := task.waitTxOnChain(key, txs, task.ethClient)
+		if err != nil {
+			logrus.Warnf("resume %s: %s", key, err)
+			continue
+		}
+		if err := task.store.RecordEra(era, txHash); err != nil {
+			logrus.Warnf("store era %d failed: %s", era, err)
+		}
+	}
+}
+
+// resumeVotes waits in the background for the voteRate txs of d left in flight
+// by an earlier run. d stays busy meanwhile, so the rate is not voted twice,
+// and the rates queued meanwhile are synced after.
+func (task *Task) resumeVotes(d *Destination) {
+	keys := task.store.InFlightKeys(store.VoteKeyPrefix(d.Name), d.client.ChainID())
+	if len(keys) == 0 || !d.busy.CompareAndSwap(false, true) {
+		return
+	}
+	utils.SafeGo(func() {
+		resumed := false
+		// d is released on a panic too, syncQueuedRates releases it otherwise
+		defer func() {
+			if !resumed {
+				d.busy.Store(false)
+			}
+		}()
+		for _, key := range keys {
+			vote, err := store.ParseVoteKey(key)
+			if err != nil {
+				logrus.Warnf("resume %s: %s", key, err)
+				continue
+			}
+			// the prefix of d also matches a destination named d/...
+			if vote.Destination != d.Name {
+				continue
+			}
+			txs, err := task.inFlightTxs(key, d.client)
+			if err != nil {
+				logrus.Warnf("resume %s: %s", key, err)
+				continue
+			}
+			if len(txs) == 0 {
+				continue
+			}
+			logrus.Infof("resume voteRate on %s proposal %s tx %s", d.Name, vote.ProposalId.String(), txs[len(txs)-1].Hash().String())
+			txHash, err := task.waitTxOnChain(key, txs, d.client)
+			if err != nil {
+				logrus.Warnf("resume %s: %s", key, err)
+				continue
+			}
+			task.recordVote(vote, txHash)
+		}
+		resumed = true
+		task.syncQueuedRates(d)
+	})
+}
+
+func (task *Task) recordVote(vote *store.VoteKey, txHash common.Hash) {
+	err := task.store.RecordVote(store.VoteRecord{
+		Destination: vote.Destination,
+		Era:         uint64(vote.Era),
+		Rate:        vote.Rate.String(),
+		ProposalId:  vote.ProposalId.String(),
+		TxHash:      txHash.String(),
+	})
+	if err != nil {
+		logrus.Warnf("store vote of %s failed: %s", vote.String(), err)
+	}
+}
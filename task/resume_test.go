@@ -0,0 +1,82 @@
+package task
+
+import (
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"rmatic-relay/pkg/store"
+)
+
+func TestResumeVotes(t *testing.T) {
+	fastRetry(t)
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, nil, "0")
+	st, err := store.Open(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{store: st, replaceTxBlocks: 10}
+	d := &Destination{Name: "polygon", client: client}
+	voteKey := func(destination string, era uint32) string {
+		return (&store.VoteKey{Destination: destination, Era: era, Rate: big.NewInt(1e18), ProposalId: common.Hash{byte(era)}}).String()
+	}
+
+	// a vote mined while the relay was down
+	mined := sendTx(t, client)
+	node.lock.Lock()
+	node.mine(mined)
+	node.lock.Unlock()
+	task.recordTx(voteKey("polygon", 7), client, mined)
+
+	// a vote whose nonce was taken by the mined one
+	from := client.Opts().From
+	dropped, err := client.Opts().Signer(from, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   client.ChainID(),
+		Nonce:     mined.Nonce(),
+		GasTipCap: big.NewInt(2e9),
+		GasFeeCap: big.NewInt(30e9),
+		Gas:       21000,
+		To:        &from,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.recordTx(voteKey("polygon", 8), client, dropped)
+
+	// a vote of another destination sharing the name prefix
+	other, err := client.Opts().Signer(from, types.NewTx(&types.DynamicFeeTx{
+		ChainID:   client.ChainID(),
+		Nonce:     mined.Nonce() + 1,
+		GasTipCap: big.NewInt(2e9),
+		GasFeeCap: big.NewInt(30e9),
+		Gas:       21000,
+		To:        &from,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.recordTx(voteKey("polygon/amoy", 9), client, other)
+
+	task.resumeVotes(d)
+	deadline := time.Now().Add(5 * time.Second)
+	for d.busy.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("destination still busy after resume")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	keys := st.InFlightKeys(store.VoteKeyPrefix("polygon"), client.ChainID())
+	if len(keys) != 1 || keys[0] != voteKey("polygon/amoy", 9) {
+		t.Fatalf("expected only the vote of polygon/amoy left in flight, got %v", keys)
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if len(node.sent) != 1 {
+		t.Fatalf("expected no tx sent on resume, got %d", len(node.sent)-1)
+	}
+}
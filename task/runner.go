@@ -0,0 +1,285 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/sirupsen/logrus"
+	"rmatic-relay/bindings/StakeManager"
+	"rmatic-relay/pkg/config"
+	"rmatic-relay/pkg/metrics"
+	"rmatic-relay/pkg/store"
+	"rmatic-relay/pkg/utils"
+	"rmatic-relay/shared"
+)
+
+var balanceInterval = time.Minute
+
+// readyTimeout bounds the rpc calls of one Ready check.
+var readyTimeout = 10 * time.Second
+
+// Runner runs a set of tasks in one process. Every task shares one client per
+// chain, while keeping its own ticker, tick counters and stop channel.
+type Runner struct {
+	ethRpcEndpoints       []string
+	signer                shared.Signer
+	gasLimit              *big.Int
+	maxGasPrice           *big.Int
+	ethStakeMangerAddress common.Address
+	ethLegacyGasPrice     bool
+	ethConfirmBlocks      string
+	ethMinBalance         *big.Int
+	readyTickIntervals    int64
+	dryRun                bool
+
+	tasks        []*Task
+	destinations []*Destination
+	stop         chan struct{}
+
+	ethClient *shared.Client
+}
+
+func NewRunner(cfg *config.Config, signer shared.Signer, taskTypes []uint8) (*Runner, error) {
+	if len(taskTypes) == 0 {
+		return nil, fmt.Errorf("no task specified")
+	}
+	gasLimit, maxGasPrice, err := parseGasConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	ethMinBalance, err := parseMinBalance(cfg.EthMinBalance)
+	if err != nil {
+		return nil, err
+	}
+	readyTickIntervals, err := strconv.ParseInt(cfg.ReadyTickIntervals, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("ready tick intervals: %w", err)
+	}
+
+	r := &Runner{
+		ethRpcEndpoints:       config.SplitEndpoints(cfg.EthRpcEndpoint),
+		signer:                signer,
+		gasLimit:              gasLimit,
+		maxGasPrice:           maxGasPrice,
+		ethStakeMangerAddress: common.HexToAddress(cfg.StakeMangerAddress),
+		ethLegacyGasPrice:     cfg.EthGasPriceMode == config.GasPriceModeLegacy,
+		ethConfirmBlocks:      cfg.EthConfirmBlocks,
+		ethMinBalance:         ethMinBalance,
+		readyTickIntervals:    readyTickIntervals,
+		dryRun:                cfg.DryRun,
+		tasks:                 make([]*Task, 0, len(taskTypes)),
+		stop:                  make(chan struct{}),
+	}
+	// a dry run sends nothing, so it neither records nor resumes txs
+	var st *store.Store
+	if !cfg.DryRun && len(cfg.StorePath) != 0 {
+		st, err = store.Open(cfg.StorePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, taskType := range taskTypes {
+		t, err := NewTask(cfg, taskType, st)
+		if err != nil {
+			return nil, err
+		}
+		r.tasks = append(r.tasks, t)
+	}
+	if r.needDestinations() {
+		for _, destinationCfg := range cfg.SyncDestinations() {
+			d, err := NewDestination(destinationCfg)
+			if err != nil {
+				return nil, err
+			}
+			r.destinations = append(r.destinations, d)
+		}
+	}
+
+	return r, nil
+}
+
+// parseMinBalance parses a wei balance threshold, empty means no threshold.
+func parseMinBalance(value string) (*big.Int, error) {
+	if len(value) == 0 {
+		return big.NewInt(0), nil
+	}
+	minBalance, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil, fmt.Errorf("min balance not integer: %s", value)
+	}
+	return minBalance, nil
+}
+
+func (r *Runner) needDestinations() bool {
+	for _, t := range r.tasks {
+		if t.taskType == utils.TaskTypeSyncRate {
+			return true
+		}
+	}
+	return false
+}
+
+// Start dials the chain clients once and starts every task on top of them.
+func (r *Runner) Start() error {
+	ethClient, err := shared.NewClient(r.ethRpcEndpoints, r.signer, r.gasLimit, r.maxGasPrice)
+	if err != nil {
+		return err
+	}
+	ethClient.SetLegacyGasPrice(r.ethLegacyGasPrice)
+	if err := ethClient.SetConfirmBlocks(r.ethConfirmBlocks); err != nil {
+		return err
+	}
+	r.ethClient = ethClient
+
+	chainId := r.ethClient.ChainID()
+	var isDev bool
+	switch chainId.Uint64() {
+	case 1:
+		isDev = false
+	case 5, 11155111:
+		isDev = true
+	default:
+		return fmt.Errorf("unsupport chainId: %d", chainId.Int64())
+	}
+
+	stakeManger, err := stake_manager.NewStakeManager(r.ethStakeMangerAddress, r.ethClient)
+	if err != nil {
+		return err
+	}
+	bondedPools, err := stakeManger.GetBondedPools(&bind.CallOpts{
+		Context: context.Background(),
+	})
+	if err != nil {
+		return err
+	}
+	if len(bondedPools) == 0 {
+		return fmt.Errorf("no bonded pools")
+	}
+
+	for _, d := range r.destinations {
+		if err := d.connect(r.signer); err != nil {
+			return err
+		}
+	}
+
+	// a dry run sends nothing, so it leaves no gap to fill
+	if !r.dryRun {
+		r.ethClient.StartNonceGapLoop()
+		for _, d := range r.destinations {
+			d.client.StartNonceGapLoop()
+		}
+	}
+
+	for i, t := range r.tasks {
+		err := t.Start(r.ethClient, r.destinations, stakeManger, isDev)
+		if err != nil {
+			// stop the tasks already started before bailing out
+			for _, started := range r.tasks[:i] {
+				started.Stop()
+			}
+			return fmt.Errorf("task %s start failed: %w", t.Name(), err)
+		}
+		logrus.Infof("task %s started", t.Name())
+	}
+
+	utils.SafeGoWithRestart(r.balanceHandler)
+	return nil
+}
+
+// balanceHandler keeps the signer balance metrics of every chain up to date.
+func (r *Runner) balanceHandler() {
+	ticker := time.NewTicker(balanceInterval)
+	defer ticker.Stop()
+
+	for {
+		r.updateBalance(r.ethClient)
+		for _, d := range r.destinations {
+			r.updateBalance(d.client)
+		}
+
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Runner) updateBalance(client *shared.Client) {
+	balance, err := client.BalanceAt(context.Background(), client.Opts().From, nil)
+	if err != nil {
+		logrus.Warnf("get balance on %s failed: %s", client.Endpoint(), err)
+		return
+	}
+	metrics.SignerBalance.WithLabelValues(metrics.ChainName(client.ChainID())).Set(metrics.WeiToEther(balance))
+}
+
+// Ready returns an error when a client can not fetch a fresh head, a signer
+// balance is below its threshold, a task has not completed a successful tick
+// within readyTickIntervals ticker intervals, or a destination has not been
+// synced within readyTickIntervals intervals of the sync rate safety ticker.
+func (r *Runner) Ready() error {
+	ctx, cancel := context.WithTimeout(context.Background(), readyTimeout)
+	defer cancel()
+
+	problems := make([]string, 0)
+	check := func(chain string, client *shared.Client, minBalance *big.Int) {
+		head, err := client.HeaderByNumber(ctx, nil)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: get head failed: %s", chain, err))
+		} else if age := time.Since(time.Unix(int64(head.Time), 0)); age > shared.MaxHeadAge {
+			problems = append(problems, fmt.Sprintf("%s: head is stale, age %s", chain, age.Round(time.Second)))
+		}
+
+		if minBalance.Sign() > 0 {
+			balance, err := client.BalanceAt(ctx, client.Opts().From, nil)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: get balance failed: %s", chain, err))
+			} else if balance.Cmp(minBalance) < 0 {
+				problems = append(problems, fmt.Sprintf("%s: balance %s below %s", chain, balance, minBalance))
+			}
+		}
+	}
+
+	if r.ethClient == nil {
+		return fmt.Errorf("not started")
+	}
+	check(metrics.ChainName(r.ethClient.ChainID()), r.ethClient, r.ethMinBalance)
+	for _, d := range r.destinations {
+		check(d.Name, d.client, d.minBalance)
+	}
+	for _, t := range r.tasks {
+		if err := t.CheckAlive(r.readyTickIntervals); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	maxSyncAge := time.Duration(r.readyTickIntervals*SyncRateSafetyTicker) * time.Second
+	for _, d := range r.destinations {
+		if err := d.checkSynced(maxSyncAge); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	if len(problems) != 0 {
+		return fmt.Errorf("not ready: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Stop closes the stop channel of every task and the shared clients.
+func (r *Runner) Stop() {
+	close(r.stop)
+	for _, t := range r.tasks {
+		t.Stop()
+	}
+	r.ethClient.Close()
+	for _, d := range r.destinations {
+		d.client.Close()
+	}
+}
@@ -0,0 +1,32 @@
+package task
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadyDestinationSynced(t *testing.T) {
+	_, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "0")
+	d := &Destination{Name: "polygon", client: client, minBalance: big.NewInt(0)}
+	r := &Runner{
+		ethClient:          client,
+		ethMinBalance:      big.NewInt(0),
+		readyTickIntervals: 2,
+		destinations:       []*Destination{d},
+	}
+
+	d.lastSynced.Store(time.Now().Unix())
+	if err := r.Ready(); err != nil {
+		t.Fatalf("expected ready, got %s", err)
+	}
+
+	// the last successful sync is older than two safety ticker intervals
+	d.lastSynced.Store(time.Now().Add(-time.Duration(3*SyncRateSafetyTicker) * time.Second).Unix())
+	err := r.Ready()
+	if err == nil || !strings.Contains(err.Error(), "destination polygon") {
+		t.Fatalf("expected the stale destination not ready, got %v", err)
+	}
+}
@@ -1,58 +1,267 @@
 package task
 
 import (
+	"errors"
 	"fmt"
 	"math/big"
 	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/sirupsen/logrus"
 	"rmatic-relay/bindings/StakePortalRate"
-	"rmatic-relay/shared"
+	"rmatic-relay/pkg/metrics"
+	"rmatic-relay/pkg/store"
+	"rmatic-relay/pkg/utils"
 )
 
-func (t Task) syncRMaticRateHandler() error {
-	rateOnEth, err := t.ethContractStakeManager.GetRate(nil)
+// syncRMaticRateHandler is the periodic comparison of the rates, the safety
+// net of executeNewEraHandler. It reads the rate on eth once and syncs it to
+// every destination in the background. Only a failure to read the rate on eth
+// is returned, the health of each destination is reported by the
+// DestinationSyncHealthy metric.
+func (t *Task) syncRMaticRateHandler() error {
+	// pin every read to one confirmed block, so that a newEra landing in
+	// between can not pair the rate with another era
+	ethBlock, err := t.ethClient.ConfirmedBlock()
+	if err != nil {
+		return err
+	}
+	ethCallOpts := &bind.CallOpts{BlockNumber: ethBlock}
+
+	rateOnEth, err := t.ethContractStakeManager.GetRate(ethCallOpts)
 	if err != nil {
 		logrus.Warnf("ethStakeManager.GetRate failed, err: %s", err.Error())
 		return err
 	}
+	latestEra, err := t.ethContractStakeManager.LatestEra(ethCallOpts)
+	if err != nil {
+		logrus.Warnf("ethStakeManager.LatestEra failed, err: %s", err.Error())
+		return err
+	}
+	err = t.checkEraRate(ethCallOpts, latestEra, rateOnEth)
+	if err != nil {
+		return err
+	}
+	metrics.Rate.WithLabelValues(metrics.ChainName(t.ethClient.ChainID())).Set(metrics.BigToFloat(rateOnEth, 18))
+
+	t.dispatchRate(rateOnEth, uint32(latestEra.Uint64()))
+	return nil
+}
+
+// checkEraRate returns an error when rate is not the EraRate of era at the block
+// of callOpts, so a proposal never pairs a rate with another era.
+func (t *Task) checkEraRate(callOpts *bind.CallOpts, era, rate *big.Int) error {
+	eraRate, err := t.ethContractStakeManager.EraRate(callOpts, era)
+	if err != nil {
+		return fmt.Errorf("ethStakeManager.EraRate error %s", err)
+	}
+	if eraRate.Cmp(rate) != 0 {
+		return fmt.Errorf("rate %s not match EraRate %s of era %d at block %s", rate.String(), eraRate.String(), era.Uint64(), callOpts.BlockNumber.String())
+	}
+	return nil
+}
+
+// dispatchRate syncs rate of era to every destination in its own goroutine, so
+// that a failing or slow destination does not hold back the others. The rate
+// is queued for a destination still busy with an earlier sync, and synced
+// once that one is done.
+func (t *Task) dispatchRate(rate *big.Int, era uint32) {
+	t.dispatchRateTo(t.destinations, rate, era)
+}
+
+// dispatchRateTo is dispatchRate limited to destinations.
+func (t *Task) dispatchRateTo(destinations []*Destination, rate *big.Int, era uint32) {
+	for _, d := range destinations {
+		d.queueRate(rate, era)
+		if !d.busy.CompareAndSwap(false, true) {
+			logrus.Debugf("destination %s is busy, rate %s of era %d queued", d.Name, rate.String(), era)
+			continue
+		}
+		d := d
+		utils.SafeGo(func() {
+			t.syncQueuedRates(d)
+		})
+	}
+}
 
-	rateOnPolygon, err := t.polygonContractStakePortalRate.GetRate(nil)
+// syncQueuedRates syncs the rates queued for d until none is left, then
+// releases d. d must be busy, held by the caller.
+func (t *Task) syncQueuedRates(d *Destination) {
+	held := true
+	// released on a panic too, or d would stay busy for good
+	defer func() {
+		if held {
+			d.busy.Store(false)
+		}
+	}()
+	for {
+		for queued := d.takeRate(); queued != nil; queued = d.takeRate() {
+			err := t.syncRateTo(d, queued.rate, queued.era)
+			if err != nil {
+				logrus.Warnf("sync rate to %s failed, err: %s", d.Name, err.Error())
+			}
+			reportSyncHealth(d, err)
+		}
+		held = false
+		d.busy.Store(false)
+		// a rate queued after the last take found d busy, sync it unless
+		// another dispatch took d meanwhile
+		if !d.hasQueuedRate() || !d.busy.CompareAndSwap(false, true) {
+			return
+		}
+		held = true
+	}
+}
+
+// reportSyncHealth sets the DestinationSyncHealthy metric and the last
+// successful sync of d from the result of a sync. A vote refused by the rate
+// change limit is a decision, not a failure of the destination.
+func reportSyncHealth(d *Destination, err error) {
+	healthy := 0.0
+	if err == nil || errors.Is(err, errRateChangeRefused) {
+		healthy = 1
+		d.lastSynced.Store(time.Now().Unix())
+	}
+	metrics.DestinationSyncHealthy.WithLabelValues(d.Name).Set(healthy)
+}
+
+func (t *Task) syncRateTo(d *Destination, rateOnEth *big.Int, latestEra uint32) error {
+	// read the destination rate and the proposals at one confirmed block, so
+	// that every relayer sees the same state when choosing the factor
+	block, err := d.client.ConfirmedBlock()
 	if err != nil {
-		logrus.Warnf("polygonStakePortalRate.GetRate failed, err: %s", err.Error())
 		return err
 	}
+	callOpts := &bind.CallOpts{BlockNumber: block}
+
+	rateOnDestination, err := d.stakePortalRate.GetRate(callOpts)
+	if err != nil {
+		return fmt.Errorf("stakePortalRate.GetRate error %s", err)
+	}
+	metrics.Rate.WithLabelValues(metrics.ChainName(d.client.ChainID())).Set(metrics.BigToFloat(rateOnDestination, 18))
 
-	if rateOnEth.Cmp(rateOnPolygon) == 0 {
+	if rateOnEth.Cmp(rateOnDestination) == 0 {
 		return nil
 	}
 
-	latestEra, err := t.ethContractStakeManager.LatestEra(nil)
+	err = t.checkRateChange(d, callOpts, rateOnDestination, rateOnEth)
 	if err != nil {
-		logrus.Warnf("ethStakeManager.LatestEra failed, err: %s", err.Error())
 		return err
 	}
-	proposalId := getProposalId(uint32(latestEra.Uint64()), rateOnEth, 0)
-	err = polygonVoteRate(t.polygonContractStakePortalRate, proposalId, rateOnEth, t.polygonClient)
+	proposalId, err := t.votableProposalId(d, callOpts, latestEra, rateOnEth)
 	if err != nil {
-		logrus.Warnf("polygonVoteRate failed, err: %s", err.Error())
 		return err
 	}
-	return nil
+	return t.voteRate(d, callOpts, proposalId, rateOnEth, latestEra)
+}
+
+// errRateChangeRefused is returned when checkRateChange refuses a vote.
+var errRateChangeRefused = errors.New("rate vote refused")
+
+// checkRateChange refuses a vote of newRate when its change relative to
+// oldRate exceeds the RateChangeLimit of StakePortalRate, which would be
+// rejected on chain, or the stricter local maxRateChange, which more likely
+// is an oracle error than a real change.
+func (t *Task) checkRateChange(d *Destination, callOpts *bind.CallOpts, oldRate, newRate *big.Int) error {
+	contractLimit, err := d.stakePortalRate.RateChangeLimit(callOpts)
+	if err != nil {
+		return fmt.Errorf("stakePortalRate.RateChangeLimit error %s", err)
+	}
+	limit, change := exceededRateLimit(oldRate, newRate, []rateLimit{
+		{"contract", contractLimit},
+		{"local", t.maxRateChange},
+	})
+	if limit == nil {
+		return nil
+	}
+	metrics.RateVoteRefused.WithLabelValues(d.Name, limit.name).Inc()
+	logrus.WithFields(logrus.Fields{
+		"destination":     d.Name,
+		"limit":           limit.name,
+		"limitValue":      limit.value.String(),
+		"change":          change.String(),
+		"destinationRate": oldRate.String(),
+		"ethRate":         newRate.String(),
+	}).Error("rate vote refused, rate change over limit")
+	return fmt.Errorf("%w: rate change %s over %s limit %s", errRateChangeRefused, change.String(), limit.name, limit.value.String())
+}
+
+// rateLimit is a bound of the rate change, in the unit of RateChangeLimit.
+type rateLimit struct {
+	name  string
+	value *big.Int
 }
 
-func polygonVoteRate(polygonStakePortalRateContract *stake_portal_rate.StakePortalRate, proposalId [32]byte, evmRate *big.Int, polygonConn *shared.Client) error {
-	proposal, err := polygonStakePortalRateContract.Proposals(nil, proposalId)
+// exceededRateLimit returns the first of limits the change from oldRate to
+// newRate exceeds, and the change. A change equal to a limit is allowed, a
+// zero limit is not checked, and no change is limited from a zero oldRate.
+func exceededRateLimit(oldRate, newRate *big.Int, limits []rateLimit) (*rateLimit, *big.Int) {
+	if oldRate.Sign() == 0 {
+		return nil, nil
+	}
+	change := rateChange(oldRate, newRate)
+	for i := range limits {
+		if limits[i].value.Sign() == 0 || change.Cmp(limits[i].value) <= 0 {
+			continue
+		}
+		return &limits[i], change
+	}
+	return nil, change
+}
+
+// rateChange returns |newRate - oldRate| * 1e18 / oldRate, in the unit of RateChangeLimit.
+func rateChange(oldRate, newRate *big.Int) *big.Int {
+	change := new(big.Int).Sub(newRate, oldRate)
+	change.Abs(change)
+	change.Mul(change, big.NewInt(1e18))
+	return change.Quo(change, oldRate)
+}
+
+// votableProposalId returns the proposal id of the lowest factor that can still
+// be voted for rate in era. A proposal is inactive (0) before its first vote,
+// active (1) while collecting votes and executed (2) once the threshold set the
+// rate. Inactive and active ones are votable. It is called only when the
+// destination rate differs from rate, so an executed proposal means the rate
+// was changed again since and a new round is needed with the next factor.
+func (t *Task) votableProposalId(d *Destination, callOpts *bind.CallOpts, era uint32, rate *big.Int) ([32]byte, error) {
+	for factor := 0; factor <= MaxProposalFactor; factor++ {
+		proposalId := GetProposalId(era, rate, factor)
+		proposal, err := d.stakePortalRate.Proposals(callOpts, proposalId)
+		if err != nil {
+			return [32]byte{}, fmt.Errorf("Proposals error %s", err)
+		}
+		if proposal.Status == ProposalStatusInactive || proposal.Status == ProposalStatusActive {
+			return proposalId, nil
+		}
+		logrus.WithFields(logrus.Fields{
+			"destination": d.Name,
+			"era":         era,
+			"rate":        rate.String(),
+			"factor":      factor,
+			"proposalId":  proposalId.String(),
+			"status":      ProposalStatusName(proposal.Status),
+		}).Warn("proposal can not be voted, move to next factor")
+	}
+	return [32]byte{}, fmt.Errorf("no votable proposal of era %d rate %s up to factor %d", era, rate.String(), MaxProposalFactor)
+}
+
+// voteRate votes evmRate for proposalId on d. The proposal and the vote of the
+// account are read at the block of callOpts, the same the proposal was chosen at.
+func (t *Task) voteRate(d *Destination, callOpts *bind.CallOpts, proposalId [32]byte, evmRate *big.Int, era uint32) error {
+	stakePortalRateContract := d.stakePortalRate
+	conn := d.client
+
+	proposal, err := stakePortalRateContract.Proposals(callOpts, proposalId)
 	if err != nil {
 		return fmt.Errorf("processSignatureEnough Proposals error %s ", err)
 	}
-	if proposal.Status == 2 { // success status
+	if proposal.Status == ProposalStatusExecuted {
 		return nil
 	}
-	hasVoted, err := polygonStakePortalRateContract.HasVoted(&bind.CallOpts{}, proposalId, polygonConn.Opts().From)
+	hasVoted, err := stakePortalRateContract.HasVoted(callOpts, proposalId, conn.Opts().From)
 	if err != nil {
 		return fmt.Errorf("processSignatureEnough HasVoted error %s", err)
 	}
@@ -60,81 +269,93 @@ func polygonVoteRate(polygonStakePortalRateContract *stake_portal_rate.StakePort
 		return nil
 	}
 
-	// send tx
-	err = polygonConn.LockAndUpdateOpts(big.NewInt(0), big.NewInt(0))
+	// simulate before sending, a revert still costs gas
+	stakePortalRateAbi, err := stake_portal_rate.StakePortalRateMetaData.GetAbi()
+	if err != nil {
+		return err
+	}
+	input, err := stakePortalRateAbi.Pack("voteRate", proposalId, evmRate)
+	if err != nil {
+		return err
+	}
+	gasLimit, err := t.simulateTx(conn, d.stakePortalRateAddress, input, d.gasLimit)
 	if err != nil {
-		return fmt.Errorf("processSignatureEnough LockAndUpdateOpts error %s", err)
+		return fmt.Errorf("processSignatureEnough simulate VoteRate error %s", err)
+	}
+
+	if t.dryRun {
+		action := fmt.Sprintf("voteRate on %s proposal %s rate %s", d.Name, common.Hash(proposalId).String(), evmRate.String())
+		return t.logDryRunTx(conn, action, d.stakePortalRateAddress, input, gasLimit)
 	}
-	polygonConn.UnlockOpts()
 
-	voteTx, err := polygonStakePortalRateContract.VoteRate(polygonConn.Opts(), proposalId, evmRate)
+	// send tx
+	voteTx, err := conn.Transact(gasLimit, big.NewInt(0), func(opts *bind.TransactOpts) (*types.Transaction, error) {
+		return stakePortalRateContract.VoteRate(opts, proposalId, evmRate)
+	})
 	if err != nil {
 		return fmt.Errorf("processSignatureEnough VoteRate error %s", err)
 	}
 
-	err = waitPolygonTxOk(voteTx.Hash(), polygonConn)
+	vote := &store.VoteKey{Destination: d.Name, Era: era, Rate: evmRate, ProposalId: proposalId}
+	t.recordTx(vote.String(), conn, voteTx)
+
+	voteTxHash, err := t.waitTxOnChain(vote.String(), []*types.Transaction{voteTx}, conn)
 	if err != nil {
 		return fmt.Errorf("processSignatureEnough waitTxOk error %s", err)
 	}
+	t.recordVote(vote, voteTxHash)
 
-	err = waitPolygonRateUpdated(polygonStakePortalRateContract, proposalId)
+	err = waitRateUpdated(stakePortalRateContract, proposalId)
 	if err != nil {
 		return fmt.Errorf("processSignatureEnough waitRateUpdated error %s", err)
 	}
 	return nil
 }
 
-func getProposalId(era uint32, rate *big.Int, factor int) common.Hash {
-	return crypto.Keccak256Hash([]byte(fmt.Sprintf("era-%d-%s-%s-%d", era, "voteRate", rate.String(), factor)))
-}
+// proposal status of StakePortalRate
+const (
+	ProposalStatusInactive uint8 = 0
+	ProposalStatusActive   uint8 = 1
+	ProposalStatusExecuted uint8 = 2
+)
 
-func waitPolygonTxOk(txHash common.Hash, polygonConn *shared.Client) error {
-	retry := 0
-	for {
-		if retry > 300 {
-			return fmt.Errorf("waitPolygonTxOk tx reach retry limit")
-		}
-		_, pending, err := polygonConn.TransactionByHash(txHash)
-		if err == nil && !pending {
-			break
-		} else {
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"tx hash": txHash,
-					"err":     err.Error(),
-				}).Warn("tx status")
-			} else {
-				logrus.WithFields(logrus.Fields{
-					"tx hash": txHash,
-					"status":  "pending",
-				}).Warn("tx status")
-			}
-			time.Sleep(6 * time.Second)
-			retry++
-			continue
-		}
+// MaxProposalFactor bounds the factors tried for one era and rate.
+var MaxProposalFactor = 16
+
+var proposalStatusNames = map[uint8]string{
+	ProposalStatusInactive: "inactive",
+	ProposalStatusActive:   "active",
+	ProposalStatusExecuted: "executed",
+}
 
+// ProposalStatusName returns the name of a StakePortalRate proposal status.
+func ProposalStatusName(status uint8) string {
+	if name, exist := proposalStatusNames[status]; exist {
+		return name
 	}
-	logrus.WithFields(logrus.Fields{
-		"tx hash": txHash,
-	}).Info("tx send ok")
-	return nil
+	return fmt.Sprintf("unknown(%d)", status)
+}
+
+// GetProposalId returns the id of the voteRate proposal of rate in era. Every
+// voter derives the same id, factor distinguishes retries of the same era and rate.
+func GetProposalId(era uint32, rate *big.Int, factor int) common.Hash {
+	return crypto.Keccak256Hash([]byte(fmt.Sprintf("era-%d-%s-%s-%d", era, "voteRate", rate.String(), factor)))
 }
 
-func waitPolygonRateUpdated(polygonStakePortalRateContract *stake_portal_rate.StakePortalRate, proposalId [32]byte) error {
+func waitRateUpdated(stakePortalRateContract *stake_portal_rate.StakePortalRate, proposalId [32]byte) error {
 	retry := 0
 	for {
 		if retry > 300 {
-			return fmt.Errorf("waitPolygonRateUpdated tx reach retry limit")
+			return fmt.Errorf("waitRateUpdated tx reach retry limit")
 		}
 
-		proposal, err := polygonStakePortalRateContract.Proposals(&bind.CallOpts{}, proposalId)
+		proposal, err := stakePortalRateContract.Proposals(&bind.CallOpts{}, proposalId)
 		if err != nil {
 			time.Sleep(6 * time.Second)
 			retry++
 			continue
 		}
-		if proposal.Status != 2 {
+		if proposal.Status != ProposalStatusExecuted {
 			time.Sleep(6 * time.Second)
 			retry++
 			continue
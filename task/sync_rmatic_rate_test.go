@@ -0,0 +1,67 @@
+package task
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+// e18 scales a decimal such as "1.01" by 1e18, the unit of the rates.
+func e18(value string) *big.Int {
+	return decimal.RequireFromString(value).Shift(18).BigInt()
+}
+
+func TestRateChange(t *testing.T) {
+	cases := []struct {
+		name             string
+		oldRate, newRate *big.Int
+		expect           *big.Int
+	}{
+		{"up", e18("1"), e18("1.01"), e18("0.01")},
+		{"down", e18("1"), e18("0.99"), e18("0.01")},
+		{"same", e18("1.2"), e18("1.2"), big.NewInt(0)},
+		{"rounded down", big.NewInt(3), big.NewInt(4), big.NewInt(333333333333333333)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if change := rateChange(c.oldRate, c.newRate); change.Cmp(c.expect) != 0 {
+				t.Fatalf("expected change %s, got %s", c.expect, change)
+			}
+		})
+	}
+}
+
+func TestExceededRateLimit(t *testing.T) {
+	cases := []struct {
+		name             string
+		oldRate, newRate *big.Int
+		contract, local  *big.Int
+		expect           string
+	}{
+		{"within both", e18("1"), e18("1.005"), e18("0.01"), e18("0.02"), ""},
+		{"over contract", e18("1"), e18("1.03"), e18("0.02"), e18("0.05"), "contract"},
+		{"over local only", e18("1"), e18("1.03"), e18("0.05"), e18("0.02"), "local"},
+		{"over both reports contract", e18("1"), e18("0.9"), e18("0.02"), e18("0.05"), "contract"},
+		{"equal to contract", e18("1"), e18("1.02"), e18("0.02"), big.NewInt(0), ""},
+		{"equal to local", e18("1"), e18("0.98"), big.NewInt(0), e18("0.02"), ""},
+		{"one wei over local", big.NewInt(1e18), big.NewInt(1.02e18 + 1), big.NewInt(0), e18("0.02"), "local"},
+		{"no limits", e18("1"), e18("3"), big.NewInt(0), big.NewInt(0), ""},
+		{"zero current rate", big.NewInt(0), e18("1"), e18("0.01"), e18("0.01"), ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			limit, _ := exceededRateLimit(c.oldRate, c.newRate, []rateLimit{
+				{"contract", c.contract},
+				{"local", c.local},
+			})
+			name := ""
+			if limit != nil {
+				name = limit.name
+			}
+			if name != c.expect {
+				t.Fatalf("expected limit %q exceeded, got %q", c.expect, name)
+			}
+		})
+	}
+}
@@ -2,137 +2,171 @@ package task
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
-	"github.com/stafiprotocol/chainbridge/utils/crypto/secp256k1"
 	"rmatic-relay/bindings/StakeManager"
-	"rmatic-relay/bindings/StakePortalRate"
 	"rmatic-relay/pkg/config"
+	"rmatic-relay/pkg/metrics"
+	"rmatic-relay/pkg/store"
 	"rmatic-relay/pkg/utils"
 	"rmatic-relay/shared"
 )
 
-type Task struct {
-	taskTicker         int64
-	stop               chan struct{}
-	ethRpcEndpoint     string
-	polygonRpcEndpoint string
-	keyPair            *secp256k1.Keypair
-	gasLimit           *big.Int
-	maxGasPrice        *big.Int
+// SyncRateSafetyTicker is the ticker seconds of the periodic rate comparison.
+var SyncRateSafetyTicker int64 = 120
+
+// MaxTxReplacements bounds the replacements of a pending tx, a tx still pending
+// replaceTxBlocks blocks after the last one is given up.
+var MaxTxReplacements = 10
 
-	ethStakeMangerAddress         common.Address
-	polygonStakePortalRateAddress common.Address
+// MaxConfirmWait bounds the wait of waitTxOnChain for a mined tx to reach the
+// confirmation depth, such as a finalized block not advancing.
+var MaxConfirmWait = 30 * time.Minute
+
+type Task struct {
+	taskTicker int64
+	stop       chan struct{}
+	gasLimit   *big.Int
+	// pending txs are replaced after this many blocks
+	replaceTxBlocks uint64
+	// dryRun logs the txs instead of sending them
+	dryRun bool
+	// local limit of the rate change, scaled by 1e18 like RateChangeLimit, 0 means none
+	maxRateChange *big.Int
+	// store records the activity and the in-flight txs, nil records nothing
+	store *store.Store
+
+	ethStakeManagerAddress common.Address
 
 	// need init on start()
 	isDev bool
 
-	ethClient                      *shared.Client
-	polygonClient                  *shared.Client
-	ethContractStakeManager        *stake_manager.StakeManager
-	polygonContractStakePortalRate *stake_portal_rate.StakePortalRate
+	ethClient               *shared.Client
+	ethContractStakeManager *stake_manager.StakeManager
+	destinations            []*Destination
 
 	taskType uint8
+
+	tickSuccess atomic.Uint64
+	tickFailure atomic.Uint64
+	// unix time of the last successful tick, or of the start
+	lastSuccess atomic.Int64
 }
 
-func NewTask(cfg *config.Config, keyPair *secp256k1.Keypair, taskType uint8) (*Task, error) {
-	gasLimitDeci, err := decimal.NewFromString(cfg.GasLimit)
+func NewTask(cfg *config.Config, taskType uint8, st *store.Store) (*Task, error) {
+	gasLimit, _, err := parseGasConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	if gasLimitDeci.LessThanOrEqual(decimal.Zero) {
-		return nil, fmt.Errorf("gas limit is zero")
+	if taskType != utils.TaskTypeNewEra && taskType != utils.TaskTypeSyncRate {
+		return nil, fmt.Errorf("task type unmatch")
 	}
-	maxGasPriceDeci, err := decimal.NewFromString(cfg.MaxGasPrice)
+
+	replaceTxBlocks, err := strconv.ParseUint(cfg.ReplaceTxBlocks, 10, 64)
 	if err != nil {
-		return nil, err
-	}
-	if maxGasPriceDeci.LessThanOrEqual(decimal.Zero) {
-		return nil, fmt.Errorf("max gas price is zero")
+		return nil, fmt.Errorf("replace tx blocks: %w", err)
 	}
 
-	if taskType != utils.TaskTypeNewEra && taskType != utils.TaskTypeSyncRate {
-		return nil, fmt.Errorf("task type unmatch")
+	taskTicker := int64(15)
+	if taskType == utils.TaskTypeSyncRate {
+		// the rate is synced on ExecuteNewEra, the ticker is the safety net
+		taskTicker = SyncRateSafetyTicker
 	}
 
 	s := &Task{
-		taskTicker:            15,
-		stop:                  make(chan struct{}),
-		ethRpcEndpoint:        cfg.EthRpcEndpoint,
-		keyPair:               keyPair,
-		gasLimit:              gasLimitDeci.BigInt(),
-		maxGasPrice:           maxGasPriceDeci.BigInt(),
-		ethStakeMangerAddress: common.HexToAddress(cfg.StakeMangerAddress),
-		taskType:              taskType,
+		taskTicker:      taskTicker,
+		stop:            make(chan struct{}),
+		gasLimit:        gasLimit,
+		replaceTxBlocks: replaceTxBlocks,
+		taskType:        taskType,
+		dryRun:          cfg.DryRun,
+		store:           st,
+
+		ethStakeManagerAddress: common.HexToAddress(cfg.StakeMangerAddress),
 	}
 
 	if taskType == utils.TaskTypeSyncRate {
-		s.polygonRpcEndpoint = cfg.PolygonRpcEndpoint
-		s.polygonStakePortalRateAddress = common.HexToAddress(cfg.PolygonStakePortalRateAddress)
+		s.maxRateChange, err = parseMaxRateChange(cfg.MaxRateChange)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return s, nil
 }
 
-func (task *Task) Start() error {
-	ethClient, err := shared.NewClient(task.ethRpcEndpoint, task.keyPair, task.gasLimit, task.maxGasPrice)
+func parseGasConfig(cfg *config.Config) (gasLimit, maxGasPrice *big.Int, err error) {
+	return parseGas(cfg.GasLimit, cfg.MaxGasPrice)
+}
+
+func parseGas(gasLimitStr, maxGasPriceStr string) (gasLimit, maxGasPrice *big.Int, err error) {
+	gasLimitDeci, err := decimal.NewFromString(gasLimitStr)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	task.ethClient = ethClient
 
-	chainId, err := task.ethClient.Client().ChainID(context.Background())
+	if gasLimitDeci.LessThanOrEqual(decimal.Zero) {
+		return nil, nil, fmt.Errorf("gas limit is zero")
+	}
+	maxGasPriceDeci, err := decimal.NewFromString(maxGasPriceStr)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	switch chainId.Uint64() {
-	case 1:
-		task.isDev = false
-	case 5,11155111:
-		task.isDev = true
-	default:
-		return fmt.Errorf("unsupport chainId: %d", chainId.Int64())
+	if maxGasPriceDeci.LessThanOrEqual(decimal.Zero) {
+		return nil, nil, fmt.Errorf("max gas price is zero")
 	}
+	return gasLimitDeci.BigInt(), maxGasPriceDeci.BigInt(), nil
+}
 
-	stakeManger, err := stake_manager.NewStakeManager(task.ethStakeMangerAddress, task.ethClient.Client())
-	if err != nil {
-		return err
+// parseMaxRateChange parses a fraction such as 0.01 into the 1e18 scaled unit
+// of RateChangeLimit, empty means no local limit.
+func parseMaxRateChange(value string) (*big.Int, error) {
+	if len(value) == 0 {
+		return big.NewInt(0), nil
 	}
-	bondedPools, err := stakeManger.GetBondedPools(&bind.CallOpts{
-		Context: context.Background(),
-	})
+	maxRateChangeDeci, err := decimal.NewFromString(value)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("max rate change: %w", err)
 	}
-	if len(bondedPools) == 0 {
-		return fmt.Errorf("no bonded pools")
+	if maxRateChangeDeci.IsNegative() {
+		return nil, fmt.Errorf("max rate change is negative")
 	}
-	task.ethContractStakeManager = stakeManger
+	return maxRateChangeDeci.Shift(18).BigInt(), nil
+}
+
+// Start binds the task to the shared clients and launches its handler.
+// destinations are only required by the sync rate task.
+func (task *Task) Start(ethClient *shared.Client, destinations []*Destination, stakeManager *stake_manager.StakeManager, isDev bool) error {
+	task.ethClient = ethClient
+	task.lastSuccess.Store(time.Now().Unix())
+	task.ethContractStakeManager = stakeManager
+	task.isDev = isDev
 
 	switch task.taskType {
 	case utils.TaskTypeNewEra:
 		utils.SafeGoWithRestart(task.newEraHandler)
 	case utils.TaskTypeSyncRate:
-		polygonClient, err := shared.NewClient(task.polygonRpcEndpoint, task.keyPair, task.gasLimit, task.maxGasPrice)
-		if err != nil {
-			return err
+		if len(destinations) == 0 {
+			return fmt.Errorf("sync rate task need destinations")
 		}
-		task.polygonClient = polygonClient
-
-		stakePortalRate, err := stake_portal_rate.NewStakePortalRate(task.polygonStakePortalRateAddress, task.polygonClient.Client())
-		if err != nil {
-			return err
+		task.destinations = destinations
+		for _, d := range destinations {
+			task.resumeVotes(d)
 		}
-		task.polygonContractStakePortalRate = stakePortalRate
 		utils.SafeGoWithRestart(task.syncRateHandler)
+		utils.SafeGoWithRestart(task.executeNewEraHandler)
 	default:
 		return fmt.Errorf("task type unmatch")
 	}
@@ -144,8 +178,39 @@ func (task *Task) Stop() {
 	close(task.stop)
 }
 
+func (task *Task) Name() string {
+	return utils.TaskTypeName(task.taskType)
+}
+
+// TickStats returns the success and failure tick counters of this task.
+func (task *Task) TickStats() (success, failure uint64) {
+	return task.tickSuccess.Load(), task.tickFailure.Load()
+}
+
+// CheckAlive returns an error when the task has not completed a successful
+// tick within maxIntervals ticker intervals.
+func (task *Task) CheckAlive(maxIntervals int64) error {
+	lastSuccess := time.Unix(task.lastSuccess.Load(), 0)
+	if since := time.Since(lastSuccess); since > time.Duration(maxIntervals*task.taskTicker)*time.Second {
+		return fmt.Errorf("task %s has no successful tick for %s", task.Name(), since.Round(time.Second))
+	}
+	return nil
+}
+
+func (task *Task) recordTick(err error) {
+	if err != nil {
+		task.tickFailure.Add(1)
+		metrics.TaskTicks.WithLabelValues(task.Name(), "failure").Inc()
+		return
+	}
+	task.tickSuccess.Add(1)
+	task.lastSuccess.Store(time.Now().Unix())
+	metrics.TaskTicks.WithLabelValues(task.Name(), "success").Inc()
+}
+
 func (task *Task) newEraHandler() {
 	logrus.Info("start new era Handler")
+	task.resumeNewEra()
 	ticker := time.NewTicker(time.Duration(task.taskTicker) * time.Second)
 	defer ticker.Stop()
 
@@ -153,11 +218,12 @@ func (task *Task) newEraHandler() {
 
 		select {
 		case <-task.stop:
-			logrus.Info("task has stopped")
+			logrus.Info("new era task has stopped")
 			return
 		case <-ticker.C:
 			logrus.Debug("newEraHandler start -----------")
 			err := task.handleNewEra()
+			task.recordTick(err)
 			if err != nil {
 				logrus.Warnf("newEraHandler failed, err: %s", err.Error())
 				continue
@@ -175,11 +241,12 @@ func (task *Task) syncRateHandler() {
 
 		select {
 		case <-task.stop:
-			logrus.Info("task has stopped")
+			logrus.Info("sync rate task has stopped")
 			return
 		case <-ticker.C:
 			logrus.Debug("syncRMaticRateHandler start -----------")
 			err := task.syncRMaticRateHandler()
+			task.recordTick(err)
 			if err != nil {
 				logrus.Warnf("syncRMaticRateHandler failed, err: %s", err.Error())
 				continue
@@ -189,68 +256,242 @@ func (task *Task) syncRateHandler() {
 	}
 }
 
-func (task *Task) waitTxOnChain(txHash common.Hash, client *shared.Client) (err error) {
-	retry := 0
-	txSuccess := false
+// simulateTx simulates a tx against the latest block and returns the gas limit
+// to send it with, its estimated gas plus the DefaultExtraGasLimit margin. The
+// estimated gas must not exceed maxGasLimit.
+func (task *Task) simulateTx(client *shared.Client, to common.Address, data []byte, maxGasLimit *big.Int) (*big.Int, error) {
+	gas, err := client.SimulateTx(to, data, big.NewInt(0))
+	if err != nil {
+		return nil, err
+	}
+	gasLimit := new(big.Int).SetUint64(gas)
+	if gasLimit.Cmp(maxGasLimit) > 0 {
+		return nil, fmt.Errorf("estimated gas %d exceeds gas limit %d", gas, maxGasLimit.Uint64())
+	}
+	return gasLimit.Add(gasLimit, shared.DefaultExtraGasLimit), nil
+}
+
+// logDryRunTx logs the unsigned tx that would have been sent in dry run mode.
+func (task *Task) logDryRunTx(client *shared.Client, action string, to common.Address, data []byte, gasLimit *big.Int) error {
+	tx, err := client.UnsignedTx(to, data, gasLimit, big.NewInt(0))
+	if err != nil {
+		return err
+	}
+	fields := logrus.Fields{
+		"action": action,
+		"from":   client.Opts().From.String(),
+		"to":     to.String(),
+		"data":   hexutil.Encode(data),
+		"gas":    tx.Gas(),
+		"nonce":  tx.Nonce(),
+		"value":  tx.Value().String(),
+	}
+	if tx.Type() == types.LegacyTxType {
+		fields["gasPrice"] = tx.GasPrice().String()
+	} else {
+		fields["gasFeeCap"] = tx.GasFeeCap().String()
+		fields["gasTipCap"] = tx.GasTipCap().String()
+	}
+	logrus.WithFields(fields).Info("dry run, tx not sent")
+	return nil
+}
+
+func recordReceipt(client *shared.Client, receipt *types.Receipt) {
+	chain := metrics.ChainName(client.ChainID())
+	if receipt.Status == 1 {
+		metrics.Txs.WithLabelValues(chain, metrics.TxEventConfirm).Inc()
+	} else {
+		metrics.Txs.WithLabelValues(chain, metrics.TxEventRevert).Inc()
+	}
+	metrics.TxGasUsed.WithLabelValues(chain).Add(float64(receipt.GasUsed))
+	if receipt.EffectiveGasPrice != nil {
+		fee := new(big.Int).Mul(receipt.EffectiveGasPrice, new(big.Int).SetUint64(receipt.GasUsed))
+		metrics.TxFee.WithLabelValues(chain).Add(metrics.WeiToEther(fee))
+	}
+}
+
+// waitTxOnChain waits until one of txs, which share one nonce, or one of their
+// replacements is mined and its receipt is confirmed by the confirmation depth
+// of client, and returns the hash of the mined one. txs holds more than the
+// sent tx only when resumed from the store. A tx pending for
+// replaceTxBlocks blocks is replaced by the same nonce with a higher fee, up
+// to maxGasPrice. A tx still pending replaceTxBlocks blocks after
+// MaxTxReplacements replacements or after reaching maxGasPrice is an error.
+// A mined tx whose receipt disappears in a reorg is sent again.
+// A mined tx not confirmed within MaxConfirmWait is an error, and so are
+// RetryLimit failed reads in a row.
+// A reverted tx is returned as a *shared.RevertError. Replacements and final
+// statuses are recorded in the store under key.
+func (task *Task) waitTxOnChain(key string, txs []*types.Transaction, client *shared.Client) (common.Hash, error) {
+	tx := txs[0]
+	hashes := make([]common.Hash, 0, len(txs))
+	sentTxs := make(map[common.Hash]*types.Transaction, len(txs))
+	for _, sent := range txs {
+		hashes = append(hashes, sent.Hash())
+		sentTxs[sent.Hash()] = sent
+	}
+	current := txs[len(txs)-1]
+	sentBlock, err := client.LatestBlock()
+	if err != nil {
+		return common.Hash{}, err
+	}
+
+	// the receipt seen last, waiting for its confirmation until confirmDeadline
+	var mined *types.Receipt
+	var confirmDeadline time.Time
+
+	// the pending phase is bounded by blocks, failures only counts the failed
+	// reads in a row
+	replacements := len(txs) - 1
+	capped := false
+	failures := 0
 	for {
-		if retry > utils.RetryLimit {
-			return fmt.Errorf("waitTxOnChain %s reach retry limit", txHash.String())
+		if failures > utils.RetryLimit {
+			return common.Hash{}, fmt.Errorf("waitTxOnChain %s reach retry limit, sent hashes: %v", tx.Hash().String(), hashes)
 		}
-		_, pending, err := client.TransactionByHash(txHash)
-		if err != nil {
-			logrus.WithFields(logrus.Fields{
-				"hash": txHash.String(),
-				"err":  err.Error(),
-			}).Warn("TransactionByHash")
 
+		// any of the hashes of this nonce may be mined, check the newest first
+		var receipt *types.Receipt
+		lookupFailed := false
+		for i := len(hashes) - 1; i >= 0; i-- {
+			receipt, err = client.TransactionReceipt(hashes[i])
+			if err == nil {
+				break
+			}
+			if !errors.Is(err, ethereum.NotFound) {
+				lookupFailed = true
+				logrus.WithFields(logrus.Fields{
+					"hash": hashes[i].String(),
+					"err":  err.Error(),
+				}).Warn("tx TransactionReceipt")
+			}
+		}
+		if receipt == nil && lookupFailed {
+			// a receipt is gone only when every hash is not found, a failed
+			// lookup is neither a reorg nor a pending tx
 			time.Sleep(utils.RetryInterval)
-			retry++
+			failures++
 			continue
-		} else {
-			if pending {
-				logrus.WithFields(logrus.Fields{
-					"hash":    txHash.String(),
-					"pending": pending,
-				}).Warn("TransactionByHash")
-
+		}
+		if receipt != nil {
+			confirmed, err := client.IsConfirmed(receipt.BlockNumber.Uint64())
+			if err != nil {
+				logrus.Warnf("check confirmation of tx %s failed: %s", receipt.TxHash.String(), err)
 				time.Sleep(utils.RetryInterval)
-				retry++
+				failures++
 				continue
-			} else {
-				// check status
-				var receipt *types.Receipt
-				subRetry := 0
-				for {
-					if subRetry > utils.RetryLimit {
-						return fmt.Errorf("TransactionReceipt %s reach retry limit", txHash.String())
-					}
-
-					receipt, err = task.ethClient.TransactionReceipt(txHash)
-					if err != nil {
-						logrus.WithFields(logrus.Fields{
-							"hash": txHash.String(),
-							"err":  err.Error(),
-						}).Warn("tx TransactionReceipt")
-
-						time.Sleep(utils.RetryInterval)
-						subRetry++
-						continue
-					}
-					break
+			}
+			if !confirmed {
+				if mined == nil {
+					confirmDeadline = time.Now().Add(MaxConfirmWait)
 				}
+				if mined == nil || mined.BlockHash != receipt.BlockHash {
+					logrus.WithFields(logrus.Fields{
+						"tx":    receipt.TxHash.String(),
+						"block": receipt.BlockNumber.Uint64(),
+					}).Info("tx mined, wait for confirmation")
+				}
+				mined = receipt
+				failures = 0
+				if time.Now().After(confirmDeadline) {
+					return common.Hash{}, fmt.Errorf("waitTxOnChain tx %s mined at block %d not confirmed within %s",
+						receipt.TxHash.String(), receipt.BlockNumber.Uint64(), MaxConfirmWait)
+				}
+				time.Sleep(utils.RetryInterval)
+				continue
+			}
 
-				if receipt.Status == 1 { //success
-					txSuccess = true
+			txSuccess := receipt.Status == 1
+			recordReceipt(client, receipt)
+			for _, hash := range hashes {
+				status := store.TxStatusReplaced
+				switch {
+				case hash != receipt.TxHash:
+				case txSuccess:
+					status = store.TxStatusSuccess
+				default:
+					status = store.TxStatusReverted
 				}
-				break
+				task.setTxStatus(hash, status)
+			}
+			logrus.WithFields(logrus.Fields{
+				"tx":         receipt.TxHash.String(),
+				"nonce":      tx.Nonce(),
+				"sent":       len(hashes),
+				"tx success": txSuccess,
+			}).Info("tx already on chain")
+			if !txSuccess {
+				return receipt.TxHash, client.NewRevertError(sentTxs[receipt.TxHash], receipt)
 			}
+			return receipt.TxHash, nil
 		}
-	}
 
-	logrus.WithFields(logrus.Fields{
-		"tx":         txHash.String(),
-		"tx success": txSuccess,
-	}).Info("tx already on chain")
+		if mined != nil {
+			// the receipt is gone, the block of the tx was reorged out
+			reorged := sentTxs[mined.TxHash]
+			metrics.Txs.WithLabelValues(metrics.ChainName(client.ChainID()), metrics.TxEventReorg).Inc()
+			logrus.WithFields(logrus.Fields{
+				"tx":    mined.TxHash.String(),
+				"block": mined.BlockNumber.Uint64(),
+			}).Warn("tx reorged out, send it again")
+			if err := client.SendTransaction(context.Background(), reorged); err != nil {
+				logrus.Warnf("send reorged tx %s again failed: %s", reorged.Hash().String(), err)
+			}
+			task.setTxStatus(reorged.Hash(), store.TxStatusPending)
+			current = reorged
+			mined = nil
+			if latestBlock, err := client.LatestBlock(); err == nil {
+				sentBlock = latestBlock
+			}
+		}
 
-	return nil
+		logrus.WithFields(logrus.Fields{
+			"hash":  current.Hash().String(),
+			"nonce": current.Nonce(),
+		}).Warn("tx pending")
+
+		latestBlock, err := client.LatestBlock()
+		if err != nil {
+			logrus.Warnf("get latest block failed: %s", err)
+			time.Sleep(utils.RetryInterval)
+			failures++
+			continue
+		}
+		if latestBlock.Uint64() >= sentBlock.Uint64()+task.replaceTxBlocks {
+			if capped || replacements >= MaxTxReplacements {
+				return common.Hash{}, fmt.Errorf("waitTxOnChain tx %s still pending %d blocks after %d replacements, sent hashes: %v",
+					current.Hash().String(), latestBlock.Uint64()-sentBlock.Uint64(), replacements, hashes)
+			}
+			newTx, err := client.ReplaceTransaction(current)
+			switch {
+			case err == nil:
+				logrus.WithFields(logrus.Fields{
+					"old hash": current.Hash().String(),
+					"new hash": newTx.Hash().String(),
+					"nonce":    newTx.Nonce(),
+				}).Info("replace pending tx")
+				task.recordTx(key, client, newTx)
+				task.setTxStatus(current.Hash(), store.TxStatusReplaced)
+				hashes = append(hashes, newTx.Hash())
+				sentTxs[newTx.Hash()] = newTx
+				current = newTx
+				sentBlock = latestBlock
+				replacements++
+			case err == shared.ErrReplacementCapped:
+				// wait one more interval for the capped tx, then give up
+				logrus.Warnf("tx %s can not be replaced: %s", current.Hash().String(), err)
+				sentBlock = latestBlock
+				capped = true
+			default:
+				// the nonce may be used already, keep looking for the receipts
+				logrus.Warnf("replace tx %s failed: %s", current.Hash().String(), err)
+				time.Sleep(utils.RetryInterval)
+				failures++
+				continue
+			}
+		}
+
+		failures = 0
+		time.Sleep(utils.RetryInterval)
+	}
 }
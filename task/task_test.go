@@ -0,0 +1,230 @@
+package task
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"rmatic-relay/pkg/utils"
+)
+
+func TestWaitTxOnChainReplaced(t *testing.T) {
+	fastRetry(t)
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "2")
+	task := &Task{replaceTxBlocks: 3}
+
+	// nothing is mined until the replacement is sent
+	node.lock.Lock()
+	node.onSend = func(n *fakeNode, tx *types.Transaction) {
+		if len(n.sent) == 2 {
+			n.mine(tx)
+		}
+	}
+	node.lock.Unlock()
+	tx := sendTx(t, client)
+	hash, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if len(node.sent) != 2 {
+		t.Fatalf("expected the tx and one replacement sent, got %d", len(node.sent))
+	}
+	replacement := node.sent[1]
+	if hash != replacement.Hash() {
+		t.Fatalf("expected the replacement %s mined, got %s", replacement.Hash(), hash)
+	}
+	if replacement.Nonce() != tx.Nonce() || replacement.GasFeeCap().Cmp(tx.GasFeeCap()) <= 0 || replacement.GasTipCap().Cmp(tx.GasTipCap()) <= 0 {
+		t.Fatalf("expected the replacement to bump the fees of nonce %d", tx.Nonce())
+	}
+}
+
+func TestWaitTxOnChainReplacementCapped(t *testing.T) {
+	fastRetry(t)
+	node, server := newFakeNode(t)
+	// the fee cap of the first tx is already at the max gas price
+	client := newFakeNodeClient(t, server.URL, big.NewInt(15e9), "0")
+	task := &Task{replaceTxBlocks: 5}
+
+	tx := sendTx(t, client)
+	node.lock.Lock()
+	start := node.head
+	// the tx is mined within the interval waited after the capped replacement
+	node.onHead = func(n *fakeNode) {
+		if n.head > start+8 && n.receipts[tx.Hash()] == nil {
+			n.mine(tx)
+		}
+	}
+	node.lock.Unlock()
+	hash, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != tx.Hash() {
+		t.Fatalf("expected the first tx mined, got %s", hash)
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if len(node.sent) != 1 {
+		t.Fatalf("expected no replacement over the max gas price, got %d sent", len(node.sent)-1)
+	}
+}
+
+func TestWaitTxOnChainGivenUp(t *testing.T) {
+	fastRetry(t)
+	defer func(max int) { MaxTxReplacements = max }(MaxTxReplacements)
+	MaxTxReplacements = 2
+
+	cases := []struct {
+		name        string
+		maxGasPrice *big.Int
+		expectSent  int
+	}{
+		{"fee cap reached", big.NewInt(15e9), 1},
+		{"max replacements", big.NewInt(1000e9), 3},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			node, server := newFakeNode(t)
+			client := newFakeNodeClient(t, server.URL, c.maxGasPrice, "0")
+			task := &Task{replaceTxBlocks: 3}
+
+			// nothing is ever mined
+			tx := sendTx(t, client)
+			if _, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client); err == nil {
+				t.Fatal("expected an error when the tx stays pending")
+			}
+			node.lock.Lock()
+			defer node.lock.Unlock()
+			if len(node.sent) != c.expectSent {
+				t.Fatalf("expected %d sent, got %d", c.expectSent, len(node.sent))
+			}
+		})
+	}
+}
+
+func TestWaitTxOnChainReplacedAfterRetryLimit(t *testing.T) {
+	fastRetry(t)
+	defer func(limit int) { utils.RetryLimit = limit }(utils.RetryLimit)
+	utils.RetryLimit = 3
+
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "0")
+	task := &Task{replaceTxBlocks: 2}
+
+	tx := sendTx(t, client)
+	node.lock.Lock()
+	// the head advances by time, the replacement is due long after
+	// RetryLimit polls
+	node.advanceEvery(20 * time.Millisecond)
+	node.onSend = func(n *fakeNode, sent *types.Transaction) {
+		n.mine(sent)
+	}
+	node.lock.Unlock()
+	hash, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if len(node.sent) != 2 || hash != node.sent[1].Hash() {
+		t.Fatalf("expected the replacement mined, got %d sent", len(node.sent))
+	}
+}
+
+func TestWaitTxOnChainReorg(t *testing.T) {
+	fastRetry(t)
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "3")
+	task := &Task{replaceTxBlocks: 100}
+
+	tx := sendTx(t, client)
+	node.lock.Lock()
+	node.mine(tx)
+	minedAt := node.head
+	// the block of the tx is reorged out before its confirmation, the resent
+	// tx is mined again
+	node.onHead = func(n *fakeNode) {
+		if n.head == minedAt+2 {
+			delete(n.receipts, tx.Hash())
+		}
+	}
+	node.onSend = func(n *fakeNode, sent *types.Transaction) {
+		n.mine(sent)
+	}
+	node.lock.Unlock()
+	hash, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != tx.Hash() {
+		t.Fatalf("expected the tx mined again, got %s", hash)
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if len(node.sent) != 2 || node.sent[1].Hash() != tx.Hash() {
+		t.Fatalf("expected the same tx sent again after the reorg, got %d sent", len(node.sent))
+	}
+	if receipt := node.receipts[tx.Hash()]; receipt == nil || receipt.BlockNumber.Uint64() <= minedAt {
+		t.Fatal("expected the tx mined again in a later block")
+	}
+}
+
+func TestWaitTxOnChainReceiptLookupFailed(t *testing.T) {
+	fastRetry(t)
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "5")
+	task := &Task{replaceTxBlocks: 100}
+
+	tx := sendTx(t, client)
+	node.lock.Lock()
+	node.mine(tx)
+	minedAt := node.head
+	// one receipt lookup after the tx was seen mined fails, the head advances
+	// once when the wait starts and once per confirmation check
+	node.onHead = func(n *fakeNode) {
+		if n.head == minedAt+2 {
+			n.failReceipts = 1
+		}
+	}
+	node.lock.Unlock()
+	hash, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != tx.Hash() {
+		t.Fatalf("expected the tx mined, got %s", hash)
+	}
+	node.lock.Lock()
+	defer node.lock.Unlock()
+	if node.failReceipts != 0 {
+		t.Fatal("expected the failed receipt lookup to happen")
+	}
+	if len(node.sent) != 1 {
+		t.Fatalf("expected no tx sent again on a failed receipt lookup, got %d sent", len(node.sent))
+	}
+}
+
+func TestWaitTxOnChainNotConfirmed(t *testing.T) {
+	fastRetry(t)
+	defer func(wait time.Duration) { MaxConfirmWait = wait }(MaxConfirmWait)
+	MaxConfirmWait = 50 * time.Millisecond
+
+	node, server := newFakeNode(t)
+	client := newFakeNodeClient(t, server.URL, big.NewInt(1000e9), "finalized")
+	task := &Task{replaceTxBlocks: 100}
+
+	tx := sendTx(t, client)
+	node.lock.Lock()
+	node.mine(tx)
+	node.lock.Unlock()
+	// the finalized block never reaches the tx
+	if _, err := task.waitTxOnChain("test", []*types.Transaction{tx}, client); err == nil {
+		t.Fatal("expected an error when the tx is never confirmed")
+	}
+}